@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A script is a parsed ed script. It's never modified once parsed, so
+// a single script can be run by several editors at once.
+type script struct {
+	cmds  []*command
+	lines []int // line of each command in the script
+}
+
+// A syntaxError is an error in a script at the line and column at
+// which parsing stopped.
+type syntaxError struct {
+	line, col int
+	err       error
+}
+
+func (e *syntaxError) Error() string { return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.err) }
+
+func (e *syntaxError) Unwrap() error { return e.err }
+
+// scanScript parses the commands of the script read from r. Parsing
+// resumes on the line after a syntax error, such that errs holds every
+// one of them, and s holds the commands that could be parsed.
+func scanScript(r io.Reader) (s *script, errs []error, err error) {
+	var (
+		sc   = bufio.NewScanner(r)
+		line int
+	)
+	next := func(bool) (string, bool) {
+		if !sc.Scan() {
+			return "", false
+		}
+		line++
+		return sc.Text(), true
+	}
+	s = &script{}
+	for ln, ok := next(false); ok; ln, ok = next(false) {
+		start := line
+		p := &parser{more: next}
+		p.doInput(ln)
+		c, err := p.command()
+		if err != nil {
+			errs = append(errs, &syntaxError{line: start + p.line, col: p.column(), err: err})
+			continue
+		}
+		s.cmds = append(s.cmds, c)
+		s.lines = append(s.lines, start)
+	}
+	return s, errs, sc.Err()
+}
+
+// parseScript parses the script read from r. The first syntax error is
+// returned, if any.
+func parseScript(r io.Reader) (*script, error) {
+	s, errs, err := scanScript(r)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	} else if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// A batchResult is the outcome of running a script on a single file.
+type batchResult struct {
+	path    string
+	written int    // bytes written by w and W
+	output  []byte // everything the script printed
+	line    int    // script line of the command that failed
+	err     error
+}
+
+// runScript runs the script on the file at path with a new editor.
+// Interactive global commands read no input and fail.
+func runScript(s *script, path string, opts ...Option) batchResult {
+	var (
+		res    = batchResult{path: path}
+		output bytes.Buffer
+	)
+	opts = append(opts, withEmbedded(), WithStdin(strings.NewReader("")), WithStdout(&output), WithStderr(&output), WithSilent(true))
+	ed := NewEditor(opts...)
+	if err := ed.read(path); err != nil {
+		res.err = err
+		return res
+	}
+	ed.undo.reset()
+	for i, c := range s.cmds {
+		ed.first, ed.second, ed.addrc = ed.dot, ed.dot, 0
+		err := ed.do(c)
+		if errors.Is(err, errQuit) {
+			break
+		} else if err != nil {
+			res.line, res.err = s.lines[i], err
+			break
+		}
+	}
+	res.written = ed.written
+	res.output = output.Bytes()
+	return res
+}
+
+// batch runs the script on every file in paths using a pool of workers
+// and returns the results in the order of paths.
+func batch(s *script, paths []string, workers int, opts ...Option) []batchResult {
+	var (
+		results = make([]batchResult, len(paths))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for range max(min(workers, len(paths)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runScript(s, paths[i], opts...)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// expandGlobs replaces the patterns in args by the files they match.
+// Arguments without matches are kept as they are.
+func expandGlobs(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{arg}
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// writeBatchResults writes the output of every file followed by its
+// outcome: the number of bytes written, or the error that stopped the
+// script. It returns the number of files that failed.
+func writeBatchResults(w io.Writer, results []batchResult) int {
+	failed := 0
+	for _, res := range results {
+		w.Write(res.output)
+		switch {
+		case res.err == nil:
+			fmt.Fprintf(w, "%s: ok: %d\n", res.path, res.written)
+		case res.line == 0:
+			fmt.Fprintf(w, "%s: failed: %s\n", res.path, res.err)
+			failed++
+		default:
+			fmt.Fprintf(w, "%s: failed: line %d: %s\n", res.path, res.line, res.err)
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	s, err := parseScript(strings.NewReader("1d\na\nx\n.\ng/a/s//b/\\\np\nw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 5, 7}; fmt.Sprint(s.lines) != fmt.Sprint(want) {
+		t.Fatalf("want lines %v, got %v", want, s.lines)
+	}
+	if _, err := parseScript(strings.NewReader("1d\np\n1,2q\n")); err == nil || err.Error() != "3:4: unexpected address" {
+		t.Fatalf("want a syntax error at 3:4, got %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt": "one\ntwo\nthree\n",
+		"b.txt": "two\n",
+		"c.txt": "four\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := parseScript(strings.NewReader("/two/s//2/\nw\n,p\nq\nw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := expandGlobs([]string{filepath.Join(dir, "*.txt"), filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := batch(s, paths, 2)
+
+	var output strings.Builder
+	if n := writeBatchResults(&output, results); n != 2 {
+		t.Fatalf("want 2 failures, got %d", n)
+	}
+	want := strings.Join([]string{
+		"one\n2\nthree\n",
+		filepath.Join(dir, "a.txt") + ": ok: 12\n",
+		"2\n",
+		filepath.Join(dir, "b.txt") + ": ok: 2\n",
+		filepath.Join(dir, "c.txt") + ": failed: line 1: no match\n",
+		filepath.Join(dir, "missing") + ": failed: cannot read input file\n",
+	}, "")
+	if output.String() != want {
+		t.Fatalf("want %q, got %q", want, output.String())
+	}
+	for name, data := range map[string]string{"a.txt": "one\n2\nthree\n", "b.txt": "2\n", "c.txt": "four\n"} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != data {
+			t.Fatalf("%s: want %q, got %q (%v)", name, data, b, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A checkpoint is the contents of the buffer saved under a name.
+type checkpoint struct {
+	name  string
+	lines []string
+	time  time.Time
+}
+
+// checkpointArgs returns the checkpoint names given to a command, which
+// takes at least least and at most most of them.
+func checkpointArgs(ed *Editor, c *command, least, most int) ([]string, error) {
+	if ed.addrc > 0 {
+		return nil, ErrUnexpectedAddress
+	}
+	names := strings.Fields(c.arg)
+	if len(names) < least {
+		return nil, ErrNoCheckpointName
+	} else if len(names) > most {
+		return nil, ErrInvalidCmdSuffix
+	}
+	return names, nil
+}
+
+// lookupCheckpoint returns the checkpoint name.
+func (ed *Editor) lookupCheckpoint(name string) (checkpoint, error) {
+	cp, ok := ed.saved[name]
+	if !ok {
+		return checkpoint{}, ErrUnknownCheckpoint
+	}
+	return cp, nil
+}
+
+// cmdCheckpoint saves the buffer under the given name, replacing any
+// checkpoint of the same name. Without a name, it lists the checkpoints
+// from the oldest to the most recent.
+func cmdCheckpoint(ed *Editor, c *command) error {
+	names, err := checkpointArgs(ed, c, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		list := make([]checkpoint, 0, len(ed.saved))
+		for _, cp := range ed.saved {
+			list = append(list, cp)
+		}
+		slices.SortFunc(list, func(a, b checkpoint) int {
+			return cmp.Or(a.time.Compare(b.time), cmp.Compare(a.name, b.name))
+		})
+		for _, cp := range list {
+			fmt.Fprintf(ed.stdout, "%s\t%d\t%s\n", cp.name, len(cp.lines), cp.time.Format(time.DateTime))
+		}
+		return nil
+	}
+	if ed.saved == nil {
+		ed.saved = make(map[string]checkpoint)
+	}
+	ed.saved[names[0]] = checkpoint{
+		name:  names[0],
+		lines: slices.Clone(ed.file.lines),
+		time:  ed.undo.clock(),
+	}
+	return nil
+}
+
+// cmdRestore replaces the buffer with a checkpoint. Only the lines that
+// differ are replaced, as a single change that can be undone.
+func cmdRestore(ed *Editor, c *command) error {
+	names, err := checkpointArgs(ed, c, 1, 1)
+	if err != nil {
+		return err
+	}
+	cp, err := ed.lookupCheckpoint(names[0])
+	if err != nil {
+		return err
+	}
+	chunks := diff(ed.file.lines, cp.lines)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		ch := chunks[i]
+		ed.splice(ch.a0+1, ch.a1, cp.lines[ch.b0:ch.b1])
+	}
+	ed.dot = len(ed.file.lines)
+	ed.undo.store(ed.g)
+	return nil
+}
+
+// cmdDiffCheckpoint writes a unified diff from a checkpoint to the
+// buffer, or from the first checkpoint to the second one.
+func cmdDiffCheckpoint(ed *Editor, c *command) error {
+	names, err := checkpointArgs(ed, c, 1, 2)
+	if err != nil {
+		return err
+	}
+	from, err := ed.lookupCheckpoint(names[0])
+	if err != nil {
+		return err
+	}
+	to := checkpoint{name: "buffer", lines: ed.file.lines}
+	if len(names) > 1 {
+		if to, err = ed.lookupCheckpoint(names[1]); err != nil {
+			return err
+		}
+	}
+	writeUnified(ed.stdout, from.lines, to.lines, diff(from.lines, to.lines), from.name, to.name, 3)
+	return nil
+}
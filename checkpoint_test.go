@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tests := []struct {
+		script string
+		output string
+		buf    []string
+		err    error
+	}{
+		{script: "checkpoint a\n2d\ncheckpoint b\n$a\nd\n.\ncheckpoint", output: "a\t3\t2024-01-01 12:00:00\nb\t2\t2024-01-01 12:00:00\n", buf: []string{"a", "c", "d"}},
+		{script: "checkpoint x\n2d\n$a\nd\n.\nrestore x", buf: []string{"a", "b", "c"}},
+		{script: "checkpoint x\n2d\n$a\nd\n.\nrestore x\nu", buf: []string{"a", "c", "d"}},
+		{script: "checkpoint x\n1,2d\ncheckpoint x\nrestore x", buf: []string{"c"}},
+		{script: "checkpoint x\n2s/b/B/\ndiff x", output: "--- x\n+++ buffer\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n", buf: []string{"a", "B", "c"}},
+		{script: "checkpoint x\n2s/b/B/\ncheckpoint y\ndiff x y", output: "--- x\n+++ y\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n", buf: []string{"a", "B", "c"}},
+		{script: "checkpoint x\ndiff x", buf: []string{"a", "b", "c"}},
+		{script: "restore x", buf: []string{"a", "b", "c"}, err: ErrUnknownCheckpoint},
+		{script: "checkpoint x\ndiff x y", buf: []string{"a", "b", "c"}, err: ErrUnknownCheckpoint},
+		{script: "restore", buf: []string{"a", "b", "c"}, err: ErrNoCheckpointName},
+		{script: "checkpoint x y", buf: []string{"a", "b", "c"}, err: ErrInvalidCmdSuffix},
+		{script: "1checkpoint x", buf: []string{"a", "b", "c"}, err: ErrUnexpectedAddress},
+	}
+	for _, test := range tests {
+		t.Run(test.script, func(t *testing.T) {
+			var output strings.Builder
+			ed := NewEditor(WithStdout(&output), WithStderr(&output), withBuffer(file{lines: []string{"a", "b", "c"}}))
+			ed.undo.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+			WithStdin(strings.NewReader(test.script))(ed)
+			var err error
+			for err == nil && ed.input.pos >= 0 {
+				err = ed.run()
+			}
+			if err == ErrFileModified {
+				err = nil // end of input
+			}
+			if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if output.String() != test.output {
+				t.Fatalf("want output %q, got %q", test.output, output.String())
+			}
+			if !slices.Equal(ed.file.lines, test.buf) {
+				t.Fatalf("want buffer %q, got %q", test.buf, ed.file.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// complete performs filename completion on ln for the commands that
+// take a file name (e, E, r, w, W, wq and f). It returns the completed
+// line, and if the completion is ambiguous, the candidates. Shell
+// commands (a file name starting with '!') are left alone.
+func complete(ln string) (string, []string) {
+	i := skipAddress(ln)
+	if i >= len(ln) || !strings.ContainsRune("eErwWf", rune(ln[i])) {
+		return ln, nil
+	}
+	cmd := ln[i]
+	i++
+	if (cmd == 'w' || cmd == 'W') && i < len(ln) && (ln[i] == 'q' || ln[i] == 'Q') {
+		i++
+	}
+	if i >= len(ln) || (ln[i] != ' ' && ln[i] != '\t') {
+		return ln, nil
+	}
+	for i < len(ln) && (ln[i] == ' ' || ln[i] == '\t') {
+		i++
+	}
+	if strings.HasPrefix(ln[i:], "!") {
+		return ln, nil
+	}
+	path, candidates := completePath(ln[i:])
+	return ln[:i] + path, candidates
+}
+
+// skipAddress returns the index of the first byte in ln that is not part
+// of an address.
+func skipAddress(ln string) int {
+	i := 0
+	for i < len(ln) {
+		switch c := ln[i]; {
+		case c >= '0' && c <= '9', strings.IndexByte(" \t.$+-^,;%", c) >= 0:
+			i++
+		case c == '\'':
+			i += 2
+		case c == '/' || c == '?':
+			for i++; i < len(ln) && ln[i] != c; i++ {
+				if ln[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// completePath completes path relative to the current directory, a
+// leading "~" is expanded to the home directory.
+func completePath(path string) (string, []string) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	dir, base := filepath.Split(path)
+	d := dir
+	if d == "" {
+		d = "."
+	}
+	entries, err := os.ReadDir(d)
+	if err != nil {
+		return path, nil
+	}
+	var matches []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || (name[0] == '.' && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(d, name)); err == nil && fi.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+	switch len(matches) {
+	case 0:
+		return path, nil
+	case 1:
+		return dir + matches[0], nil
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		n := 0
+		for n < len(prefix) && n < len(m) && prefix[n] == m[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(prefix) > len(base) {
+		return dir + prefix, nil
+	}
+	return path, matches
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.txt", "alphabet.txt", "beta.txt", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", dir)
+
+	tests := []struct {
+		ln         string
+		want       string
+		candidates []string
+	}{
+		{ln: "e " + dir + "/b", want: "e " + dir + "/beta.txt"},
+		{ln: "E " + dir + "/b", want: "E " + dir + "/beta.txt"},
+		{ln: "1,5w " + dir + "/be", want: "1,5w " + dir + "/beta.txt"},
+		{ln: "wq " + dir + "/be", want: "wq " + dir + "/beta.txt"},
+		{ln: "/x/,$W " + dir + "/be", want: "/x/,$W " + dir + "/beta.txt"},
+		{ln: "$r " + dir + "/s", want: "$r " + dir + "/sub/"},
+		{ln: "f " + dir + "/a", want: "f " + dir + "/alpha"},
+		{ln: "e " + dir + "/alpha", want: "e " + dir + "/alpha", candidates: []string{"alpha.txt", "alphabet.txt"}},
+		{ln: "e " + dir + "/.h", want: "e " + dir + "/.hidden"},
+		{ln: "e ~/be", want: "e " + dir + "/beta.txt"},
+		{ln: "e ~", want: "e " + dir + "/"},
+		{ln: "e " + dir + "/none", want: "e " + dir + "/none"},
+		{ln: "e !ls " + dir + "/b", want: "e !ls " + dir + "/b"},
+		{ln: "r !cat ~/b", want: "r !cat ~/b"},
+		{ln: "s/a/b", want: "s/a/b"},
+		{ln: "ex", want: "ex"},
+		{ln: "", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.ln, func(t *testing.T) {
+			got, candidates := complete(test.ln)
+			if got != test.want {
+				t.Fatalf("want %q, got %q", test.want, got)
+			}
+			if !slices.Equal(candidates, test.candidates) {
+				t.Fatalf("want candidates %q, got %q", test.candidates, candidates)
+			}
+		})
+	}
+}
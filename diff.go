@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// A diffChunk describes a change where the lines a[a0:a1] are replaced
+// by the lines b[b0:b1]. Either side may be empty.
+type diffChunk struct {
+	a0, a1 int
+	b0, b1 int
+}
+
+// touches reports whether the chunk affects the lines first through last
+// of b. A deletion touches the lines on either side of it.
+func (c diffChunk) touches(first, last int) bool {
+	if c.b0 == c.b1 {
+		return c.b0 >= first-1 && c.b0 <= last
+	}
+	return c.b0 < last && c.b1 > first-1
+}
+
+// differ computes the shortest edit script between two slices of lines
+// using the linear space variant of Myers' O(ND) difference algorithm.
+type differ struct {
+	a, b     []string
+	del, ins []bool // lines deleted from a and inserted in b
+	vf, vb   []int  // furthest reaching x per diagonal, forward and reverse
+	voff     int    // offset of diagonal 0 in vf and vb
+}
+
+// diff returns the chunks that turn a into b, in ascending order.
+func diff(a, b []string) []diffChunk {
+	d := &differ{
+		a:   a,
+		b:   b,
+		del: make([]bool, len(a)),
+		ins: make([]bool, len(b)),
+	}
+	maxd := (len(a) + len(b) + 1) / 2
+	d.voff = maxd + 1
+	d.vf = make([]int, 2*maxd+3)
+	d.vb = make([]int, 2*maxd+3)
+	d.compare(0, len(a), 0, len(b))
+
+	var chunks []diffChunk
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		if i < len(a) && j < len(b) && !d.del[i] && !d.ins[j] {
+			i++
+			j++
+			continue
+		}
+		c := diffChunk{a0: i, b0: j}
+		for i < len(a) && d.del[i] {
+			i++
+		}
+		for j < len(b) && d.ins[j] {
+			j++
+		}
+		c.a1, c.b1 = i, j
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+	switch {
+	case a0 == a1:
+		for ; b0 < b1; b0++ {
+			d.ins[b0] = true
+		}
+	case b0 == b1:
+		for ; a0 < a1; a0++ {
+			d.del[a0] = true
+		}
+	default:
+		x, y := d.split(a0, a1, b0, b1)
+		d.compare(a0, x, b0, y)
+		d.compare(x, a1, y, b1)
+	}
+}
+
+// split finds a point on an optimal path through the edit graph of
+// a[a0:a1] and b[b0:b1] by running the search from both ends until the
+// paths overlap.
+func (d *differ) split(a0, a1, b0, b1 int) (int, int) {
+	var (
+		n, m  = a1 - a0, b1 - b0
+		delta = n - m
+		odd   = delta&1 != 0
+		vf    = d.vf
+		vb    = d.vb
+		off   = d.voff
+	)
+	vf[off+1], vb[off+1] = 0, 0
+	for D := 0; D <= (n+m+1)/2; D++ {
+		for k := -D; k <= D; k += 2 {
+			x := vf[off+k-1] + 1
+			if k == -D || (k != D && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			}
+			y := x - k
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if r := delta - k; odd && r >= -(D-1) && r <= D-1 && x+vb[off+r] >= n {
+				return a0 + x, b0 + y
+			}
+		}
+		for k := -D; k <= D; k += 2 {
+			x := vb[off+k-1] + 1
+			if k == -D || (k != D && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			}
+			y := x - k
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			if f := delta - k; !odd && f >= -D && f <= D && x+vf[off+f] >= n {
+				return a1 - x, b1 - y
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// diffRange formats a range of n lines starting at the zero based
+// index start the way it is written in unified diff hunk headers.
+func diffRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// writeUnified writes the chunks as a unified diff with context lines
+// of context.
+func writeUnified(w io.Writer, a, b []string, chunks []diffChunk, labelA, labelB string, context int) {
+	if len(chunks) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", labelA, labelB)
+	for len(chunks) > 0 {
+		n := 1
+		for n < len(chunks) && chunks[n].a0-chunks[n-1].a1 <= 2*context {
+			n++
+		}
+		hunk := chunks[:n]
+		chunks = chunks[n:]
+
+		first, last := hunk[0], hunk[len(hunk)-1]
+		before := min(context, first.a0)
+		after := min(context, len(a)-last.a1)
+		as, bs := first.a0-before, first.b0-before
+		al, bl := last.a1+after-as, last.b1+after-bs
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", diffRange(as, al), diffRange(bs, bl))
+		pos := as
+		for _, c := range hunk {
+			for ; pos < c.a0; pos++ {
+				fmt.Fprintf(w, " %s\n", a[pos])
+			}
+			for _, ln := range a[c.a0:c.a1] {
+				fmt.Fprintf(w, "-%s\n", ln)
+			}
+			for _, ln := range b[c.b0:c.b1] {
+				fmt.Fprintf(w, "+%s\n", ln)
+			}
+			pos = c.a1
+		}
+		for ; pos < last.a1+after; pos++ {
+			fmt.Fprintf(w, " %s\n", a[pos])
+		}
+	}
+}
+
+// writeEdScript writes the chunks as an ed script (like diff -e) that
+// turns a into b. The commands are written in reverse order so that the
+// line numbers stay valid as the script is applied. A line consisting
+// of a single period is written as ".." and fixed up with a substitution.
+func writeEdScript(w io.Writer, b []string, chunks []diffChunk) {
+	for i := len(chunks) - 1; i >= 0; i-- {
+		c := chunks[i]
+		addr := fmt.Sprint(c.a0 + 1)
+		if c.a1-c.a0 > 1 {
+			addr = fmt.Sprintf("%d,%d", c.a0+1, c.a1)
+		}
+		switch {
+		case c.a0 == c.a1:
+			fmt.Fprintf(w, "%da\n", c.a0)
+		case c.b0 == c.b1:
+			fmt.Fprintf(w, "%sd\n", addr)
+			continue
+		default:
+			fmt.Fprintf(w, "%sc\n", addr)
+		}
+		for j, ln := range b[c.b0:c.b1] {
+			if ln != "." {
+				fmt.Fprintln(w, ln)
+				continue
+			}
+			fmt.Fprint(w, "..\n.\ns/.//\n")
+			if c.b0+j+1 < c.b1 {
+				fmt.Fprintln(w, "a")
+			}
+		}
+		if b[c.b1-1] != "." {
+			fmt.Fprintln(w, ".")
+		}
+	}
+}
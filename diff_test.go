@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lcsLen returns the length of the longest common subsequence of a and b.
+func lcsLen(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func randomLines(r *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = string(rune('a' + r.Intn(4)))
+	}
+	return lines
+}
+
+// applyEdScript runs script on a buffer holding lines and returns the result.
+func applyEdScript(t *testing.T, lines []string, script string) []string {
+	t.Helper()
+	ed := NewEditor(
+		WithStdin(strings.NewReader(script)),
+		WithStdout(io.Discard),
+		WithStderr(io.Discard),
+		withBuffer(file{lines: slices.Clone(lines)}),
+	)
+	for {
+		err := ed.run()
+		if ed.input.pos < 0 {
+			break
+		}
+		if err != nil && err != ErrFileModified {
+			t.Fatalf("script %q: %v", script, err)
+		}
+	}
+	return ed.file.lines
+}
+
+func TestDiff(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(r, r.Intn(12)), randomLines(r, r.Intn(12))
+		chunks := diff(a, b)
+		var (
+			got  []string
+			pos  int
+			kept int
+		)
+		for _, c := range chunks {
+			got = append(got, a[pos:c.a0]...)
+			got = append(got, b[c.b0:c.b1]...)
+			kept += c.a0 - pos
+			pos = c.a1
+		}
+		got = append(got, a[pos:]...)
+		kept += len(a) - pos
+		if !slices.Equal(got, b) {
+			t.Fatalf("diff(%q, %q) = %v applies to %q", a, b, chunks, got)
+		}
+		if want := lcsLen(a, b); kept != want {
+			t.Fatalf("diff(%q, %q) keeps %d lines, want %d", a, b, kept, want)
+		}
+	}
+}
+
+func TestWriteUnified(t *testing.T) {
+	a := strings.Split("a b c d e f g h i j k l m n o p", " ")
+	b := strings.Split("a B c d e f g h i j k l m o p q", " ")
+	var output bytes.Buffer
+	writeUnified(&output, a, b, diff(a, b), "old", "new", 3)
+	want := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,6 +11,6 @@
+ k
+ l
+ m
+-n
+ o
+ p
++q
+`
+	if output.String() != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, output.String())
+	}
+
+	output.Reset()
+	writeUnified(&output, nil, []string{"x"}, diff(nil, []string{"x"}), "old", "new", 3)
+	if want := "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n"; output.String() != want {
+		t.Fatalf("want %q, got %q", want, output.String())
+	}
+}
+
+func TestWriteEdScript(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want string
+	}{
+		{a: []string{"a", "b", "c"}, b: []string{"a", "c"}, want: "2d\n"},
+		{a: []string{"a", "b"}, b: []string{"x", "a", "b", "y"}, want: "2a\ny\n.\n0a\nx\n.\n"},
+		{a: []string{"a", "b", "c"}, b: []string{"a", "x", "y", "c"}, want: "2c\nx\ny\n.\n"},
+		{a: []string{"a"}, b: []string{"a", ".", "b", "."}, want: "1a\n..\n.\ns/.//\na\nb\n..\n.\ns/.//\n"},
+	}
+	for _, test := range tests {
+		var output bytes.Buffer
+		writeEdScript(&output, test.b, diff(test.a, test.b))
+		if output.String() != test.want {
+			t.Fatalf("want %q, got %q", test.want, output.String())
+		}
+		if got := applyEdScript(t, test.a, output.String()); !slices.Equal(got, test.b) {
+			t.Fatalf("script %q turns %q into %q, want %q", output.String(), test.a, got, test.b)
+		}
+	}
+}
+
+func TestCmdDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	buf := file{lines: []string{"a", "B", "c", "d", "e", "f", "g", "h", "i", "J"}, path: path}
+	tests := []struct {
+		cmd  string
+		want string
+		err  error
+	}{
+		{cmd: "D", want: "--- " + path + "\n+++ " + path + "\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -7,4 +7,4 @@\n g\n h\n i\n-j\n+J\n"},
+		{cmd: "De", want: "10c\nJ\n.\n2c\nB\n.\n"},
+		{cmd: "5,$De", want: "10c\nJ\n.\n"},
+		{cmd: "4,6D", want: ""},
+		{cmd: "De " + path, want: "10c\nJ\n.\n2c\nB\n.\n"},
+		{cmd: "Dx", err: ErrUnexpectedCmdSuffix},
+		{cmd: "D /non-existing-file", err: ErrCannotReadFile},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			var output bytes.Buffer
+			f := buf
+			f.lines = slices.Clone(buf.lines)
+			ed := NewEditor(WithStdin(strings.NewReader(test.cmd)), WithStdout(&output), withBuffer(f))
+			if err := ed.run(); err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+		})
+	}
+}
+
+func TestEdDiffRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(2))
+	words := []string{"a", "b", "c", ".", ""}
+	for i := 0; i < 200; i++ {
+		var content [2]string
+		for j := range content {
+			var sb strings.Builder
+			for n := r.Intn(10); n > 0; n-- {
+				sb.WriteString(words[r.Intn(len(words))] + "\n")
+			}
+			content[j] = sb.String()
+		}
+		a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+		if err := os.WriteFile(a, []byte(content[0]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(b, []byte(content[1]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var script bytes.Buffer
+		status, err := edDiff(&script, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]int{true: 0, false: 1}[content[0] == content[1]]; status != want {
+			t.Fatalf("%q -> %q: want status %d, got %d", content[0], content[1], want, status)
+		}
+		ed := NewEditor(
+			WithStdout(io.Discard),
+			WithStderr(io.Discard),
+			WithFile(a),
+			WithStdin(strings.NewReader(script.String()+"w\n")),
+		)
+		for {
+			err := ed.run()
+			if ed.input.pos < 0 {
+				break
+			}
+			if err != nil {
+				t.Fatalf("script %q: %v", script.String(), err)
+			}
+		}
+		got, err := os.ReadFile(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content[1] {
+			t.Fatalf("script %q turns %q into %q, want %q", script.String(), content[0], got, content[1])
+		}
+	}
+
+	if status, err := edDiff(io.Discard, filepath.Join(dir, "non-existing"), filepath.Join(dir, "b")); status != 2 || err == nil {
+		t.Fatalf("want status 2 and an error, got %d, %v", status, err)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
-	"strconv"
+	"slices"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -23,11 +23,15 @@ var (
 	ErrCannotOpenFile      = errors.New("cannot open input file")
 	ErrCannotReadFile      = errors.New("cannot read input file")
 	ErrCannotWriteFile     = errors.New("cannot write file")
+	ErrCannotWriteUndo     = errors.New("cannot write undo file")
+	ErrCmdExists           = errors.New("command already exists")
 	ErrCryptUnavailable    = errors.New("crypt unavailable")
 	ErrDestinationExpected = errors.New("destination expected")
 	ErrFileModified        = errors.New("warning: file modified")
+	ErrHunkFailed          = errors.New("hunk failed")
 	ErrInterrupt           = errors.New("interrupt")
 	ErrInvalidAddress      = errors.New("invalid address")
+	ErrInvalidCmdName      = errors.New("invalid command name")
 	ErrInvalidCmdSuffix    = errors.New("invalid command suffix")
 	ErrInvalidDestination  = errors.New("invalid destination")
 	ErrInvalidFileName     = errors.New("invalid filename")
@@ -35,19 +39,29 @@ var (
 	ErrInvalidNumber       = errors.New("number out of range")
 	ErrInvalidPatternDelim = errors.New("invalid pattern delimiter")
 	ErrInvalidRedirection  = errors.New("invalid redirection")
+	ErrInvalidTimeOffset   = errors.New("invalid time offset")
+	ErrMalformedPatch      = errors.New("malformed patch")
+	ErrNoCheckpointName    = errors.New("no checkpoint name")
 	ErrNoCmd               = errors.New("no command")
 	ErrNoFileName          = errors.New("no current filename")
 	ErrNoMatch             = errors.New("no match")
 	ErrNoPrevPattern       = errors.New("no previous pattern")
 	ErrNoPreviousCmd       = errors.New("no previous command")
 	ErrNoPreviousSub       = errors.New("no previous substitution")
+	ErrNothingToRedo       = errors.New("nothing to redo")
 	ErrNothingToUndo       = errors.New("nothing to undo")
 	ErrNumberOutOfRange    = errors.New("number out of range")
+	ErrShellDisabled       = errors.New("shell commands disabled")
 	ErrUnexpectedAddress   = errors.New("unexpected address")
 	ErrUnexpectedCmdSuffix = errors.New("unexpected command suffix")
 	ErrUnexpectedEOF       = errors.New("unexpected end-of-file")
+	ErrUnknownCheckpoint   = errors.New("unknown checkpoint")
 	ErrUnknownCmd          = errors.New("unknown command")
 	ErrZero                = errors.New("0")
+
+	// errQuit is returned by q and Q in an embedded editor, where
+	// quitting only ends the session.
+	errQuit = errors.New("quit")
 )
 
 type suffix int
@@ -58,6 +72,18 @@ const (
 	suffixEnumerate
 )
 
+// ANSI escape sequences used to highlight regular expression matches.
+const (
+	highlightStart = "\x1b[1;31m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// The terminal dimensions assumed when they can't be detected.
+const (
+	DefaultRows = 24
+	DefaultCols = 72
+)
+
 const DefaultShell = "/bin/sh"
 const DefaultHangupFile = "ed.hup"
 const DefaultPrompt = "*"
@@ -69,21 +95,45 @@ type Editor struct {
 	input
 
 	re      *regexp.Regexp // previous regex
+	pattern string         // previous pattern, without case folding
 	replace string         // previous replacement text
 	scroll  int            // previous scroll value
 	err     error          // previous error
-	gcmd    string         // previous global command
-
-	g    bool  // global command state
-	list []int // indices marked by the global command
-
-	prompt  bool           // state for rendering the prompt
-	up      string         // user prompt
-	verbose bool           // toggle verbose errors
-	silent  bool           // suppress diagnostics
-	script  bool           // stdin is a file
-	lc      int            // line count (script mode)
-	sigch   chan os.Signal // signal handlers
+	gcmd    []*command     // previous command list of G and V
+
+	g       bool     // global command state
+	list    []int    // indices marked by the global command
+	current *command // command being executed
+	running *command // innermost command being executed, such as one of a global command list
+
+	prompt    bool                   // state for rendering the prompt
+	up        string                 // user prompt
+	verbose   bool                   // toggle verbose errors
+	silent    bool                   // suppress diagnostics
+	script    bool                   // stdin is a file
+	highlight bool                   // highlight matches of the previous regex
+	smartcase bool                   // patterns without upper case letters ignore case
+	pager     bool                   // pause long output one screen at a time
+	rows      int                    // terminal height
+	cols      int                    // terminal width
+	utf8      bool                   // the locale uses UTF-8
+	strict    bool                   // escape all non-ASCII characters in list mode
+	dryrun    bool                   // print the changes instead of writing them
+	noshell   bool                   // refuse to run shell commands
+	refused   string                 // first shell command that was refused
+	keepundo  bool                   // keep the undo history when editing another file
+	undofile  bool                   // keep the undo history of files in undo files
+	orig      []string               // buffer contents at startup (dry-run mode)
+	embedded  bool                   // driven by a program: no signal handling, quitting returns errQuit
+	written   int                    // bytes written by w and W
+	observers []func(Change)         // called for every change to the buffer
+	rec       *recorder              // transcript of the session
+	commands  map[string]CommandFunc // registered commands
+	saved     map[string]checkpoint  // checkpoints by name
+	fs        FS                     // file system that files are read from and written to
+	lc        int                    // line count (script mode)
+	sigch     chan os.Signal         // signal handlers
+	winch     chan struct{}          // the terminal was resized
 
 	cs suffix // command suffix
 
@@ -97,7 +147,7 @@ type Option func(*Editor)
 func WithStdin(stdin io.Reader) Option {
 	return func(ed *Editor) {
 		ed.stdin = stdin
-		ed.input = input{Scanner: bufio.NewScanner(ed.stdin)}
+		ed.input = input{Scanner: bufio.NewScanner(ed.stdin), rec: ed.input.rec}
 	}
 }
 
@@ -113,6 +163,31 @@ func WithSilent(t bool) Option {
 	return func(ed *Editor) { ed.silent = t }
 }
 
+// WithHighlight highlights the matches of the previous regular
+// expression when printing lines. It has no effect unless stdout is a
+// terminal.
+func WithHighlight(t bool) Option {
+	return func(ed *Editor) { ed.highlight = t }
+}
+
+// WithPager pauses output that does not fit on the terminal one screen
+// at a time. It has no effect unless stdin is a terminal.
+func WithPager(t bool) Option {
+	return func(ed *Editor) { ed.pager = t }
+}
+
+// WithStrictList makes the l command escape every non-ASCII character,
+// even if the locale uses UTF-8.
+func WithStrictList(t bool) Option {
+	return func(ed *Editor) { ed.strict = t }
+}
+
+// WithSmartCase makes patterns that don't contain any upper case
+// letters match case-insensitively.
+func WithSmartCase(t bool) Option {
+	return func(ed *Editor) { ed.smartcase = t }
+}
+
 func WithPrompt(prompt string) Option {
 	return func(ed *Editor) {
 		ed.up = prompt
@@ -120,11 +195,69 @@ func WithPrompt(prompt string) Option {
 	}
 }
 
+// WithDryRun makes the editor leave files untouched. Write commands
+// only report the number of bytes they would have written, shell
+// commands fail with ErrShellDisabled, and the changes made to the buffer
+// are printed as a unified diff when the editor quits.
+func WithDryRun(t bool) Option {
+	return func(ed *Editor) {
+		ed.dryrun = t
+		ed.noshell = t
+	}
+}
+
+// WithKeepUndo keeps the undo history when the e and E commands edit
+// another file, so that the previous buffer and its file name can be
+// restored with u.
+func WithKeepUndo(t bool) Option {
+	return func(ed *Editor) {
+		ed.keepundo = t
+	}
+}
+
+// WithUndoLimit limits the undo history to the given number of
+// commands and bytes of lines kept to undo them. The oldest commands are
+// forgotten first, but the last one can always be undone. A limit of 0
+// means no limit.
+func WithUndoLimit(entries, bytes int) Option {
+	return func(ed *Editor) {
+		ed.undo.limit, ed.undo.max = entries, bytes
+	}
+}
+
+// withoutShell makes shell commands fail with ErrShellDisabled. It
+// must precede WithFile.
+func withoutShell() Option {
+	return func(ed *Editor) {
+		ed.noshell = true
+	}
+}
+
+// withEmbedded makes the editor suitable for being driven by a program,
+// such as a batch script or an editor integration.
+func withEmbedded() Option {
+	return func(ed *Editor) {
+		ed.embedded = true
+	}
+}
+
+// WithFS makes the editor read and write files in fsys rather than the
+// file system of the operating system. It must precede WithFile.
+func WithFS(fsys FS) Option {
+	return func(ed *Editor) {
+		ed.fs = fsys
+	}
+}
+
 func WithFile(path string) Option {
 	return func(ed *Editor) {
 		if err := ed.read(path); err != nil {
 			ed.errorln(true, err)
 		}
+		ed.undo.reset()
+		if ed.undofile {
+			ed.loadUndo()
+		}
 	}
 }
 
@@ -134,7 +267,12 @@ func NewEditor(opts ...Option) *Editor {
 		stdout: os.Stdout,
 		stderr: os.Stderr,
 		sigch:  make(chan os.Signal, 1),
+		winch:  make(chan struct{}, 1),
 		lc:     1,
+		rows:   DefaultRows,
+		cols:   DefaultCols,
+		utf8:   utf8Locale(),
+		fs:     OSFS{},
 	}
 	if fi, err := os.Stdin.Stat(); err == nil {
 		ed.script = fi.Mode()&os.ModeCharDevice == 0
@@ -142,10 +280,52 @@ func NewEditor(opts ...Option) *Editor {
 	for _, opt := range opts {
 		opt(ed)
 	}
-	go ed.handleSignals()
+	if ed.dryrun {
+		ed.orig = slices.Clone(ed.file.lines)
+	}
+	if f, ok := ed.stdin.(*os.File); ok && isTerminal(int(f.Fd())) {
+		ed.input.le = &lineEditor{
+			fd:       int(f.Fd()),
+			r:        bufio.NewReader(f),
+			w:        ed.stdout,
+			complete: complete,
+			prompt: func() string {
+				if ed.prompt {
+					return ed.up
+				}
+				return ""
+			},
+		}
+	}
+	if f, ok := ed.stdout.(*os.File); !ok || !isTerminal(int(f.Fd())) {
+		ed.highlight = false
+	}
+	ed.updateWinsize()
+	if ed.rec != nil {
+		ed.startRecording()
+	}
+	if !ed.embedded {
+		go ed.handleSignals()
+	}
 	return ed
 }
 
+// updateWinsize updates the terminal dimensions from stdout. It's only
+// called by the command loop, the signal handler asks for it on winch.
+func (ed *Editor) updateWinsize() {
+	w := ed.stdout
+	if rw, ok := w.(*recordWriter); ok {
+		w = rw.w
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return
+	}
+	if rows, cols, err := winsize(int(f.Fd())); err == nil && rows > 0 && cols > 0 {
+		ed.rows, ed.cols = rows, cols
+	}
+}
+
 func (ed *Editor) validatePath(path string) (string, error) {
 	if path != "" {
 		return path, nil
@@ -156,6 +336,63 @@ func (ed *Editor) validatePath(path string) (string, error) {
 	return ed.path, nil
 }
 
+// compile compiles the regular expression search, or the previous
+// pattern if search is empty. The expression ignores case if fold is
+// set, or if smart case is enabled and search has no upper case letters.
+// If multi is set, ^ and $ also match at the start and end of each line
+// of a multi-line text.
+func (ed *Editor) compile(search string, fold, multi bool) (*regexp.Regexp, error) {
+	if search == "" {
+		if ed.re == nil {
+			return nil, ErrNoPrevPattern
+		}
+		if ed.pattern == "" {
+			return ed.re, nil
+		}
+		search = ed.pattern
+	}
+	var flags string
+	if fold || (ed.smartcase && !hasUpper(search)) {
+		flags += "i"
+	}
+	if multi {
+		flags += "m"
+	}
+	expr := search
+	if flags != "" {
+		expr = "(?" + flags + ")" + search
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	ed.pattern = search
+	return re, nil
+}
+
+// hasUpper reports whether the pattern contains an upper case letter,
+// ignoring escape sequences such as \S or \p{Lu}.
+func hasUpper(pattern string) bool {
+	for i := 0; i < len(pattern); {
+		r, w := utf8.DecodeRuneInString(pattern[i:])
+		i += w
+		if r == '\\' && i < len(pattern) {
+			next, nw := utf8.DecodeRuneInString(pattern[i:])
+			i += nw
+			if (next == 'p' || next == 'P') && strings.HasPrefix(pattern[i:], "{") {
+				if n := strings.IndexByte(pattern[i:], '}'); n >= 0 {
+					i += n + 1
+				}
+			}
+			continue
+		}
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ed *Editor) validate(f, s int) error {
 	if ed.addrc == 0 {
 		ed.first = f
@@ -167,38 +404,47 @@ func (ed *Editor) validate(f, s int) error {
 	return nil
 }
 
+// validateAfter checks that text can be added after the line n, which
+// may be 0 to add it before the first line.
+func (ed *Editor) validateAfter(n int) error {
+	if n < 0 || n > len(ed.file.lines) {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
 func (ed *Editor) doPrompt() {
 	if ed.prompt && ed.up != "" {
 		fmt.Fprint(ed.stdout, ed.up)
 	}
 }
 
-func (ed *Editor) errorln(verbose bool, err error) {
-	if ed.token() == 'H' {
-		return
-	} else if ed.token() == 'h' {
-		ed.consume()
-		if ed.err != nil {
+// errorln reports err. An error in a script is fatal: the editor exits,
+// or if it's embedded, errQuit is returned.
+func (ed *Editor) errorln(verbose bool, err error) error {
+	if c := ed.current; c != nil && (c.name == 'h' || c.name == 'H') {
+		if c.name == 'h' || ed.verbose {
 			fmt.Fprintln(ed.stderr, ed.err)
 		}
-		return
+		return nil
 	}
 	ed.err = err
 	if verbose {
 		if ed.script {
 			fmt.Fprintf(ed.stderr, "script, line: %d: %s\n", ed.lc, ed.err)
-			os.Exit(2)
+			return ed.exit(2)
 		}
 		fmt.Fprintln(ed.stderr, err)
-		return
+		return nil
 	}
 	fmt.Fprintln(ed.stderr, ErrDefault)
+	return nil
 }
 
 func (ed *Editor) run() error {
 	ed.doPrompt()
 	if !ed.input.Scan() {
-		if !ed.file.dirty {
+		if !ed.file.dirty || ed.dryrun {
 			ed.input.pos = -1
 			return nil
 		}
@@ -208,15 +454,51 @@ func (ed *Editor) run() error {
 	if ed.script {
 		ed.lc++
 	}
-	if err := ed.parse(); err != nil {
+	select {
+	case <-ed.winch:
+		ed.updateWinsize()
+	default:
+	}
+	ed.current = nil
+	ed.first, ed.second, ed.addrc = ed.dot, ed.dot, 0
+	c, err := ed.parse(ed.input.buf)
+	if err != nil {
 		return err
 	}
-	if err := ed.exec(); err != nil {
+	return ed.do(c)
+}
+
+// parse parses the command line ln, reading any following lines from
+// the input, and recognizes the registered commands.
+func (ed *Editor) parse(ln string) (*command, error) {
+	p := &parser{more: ed.nextLine, custom: ed.registered, check: ed.checkText}
+	p.doInput(ln)
+	return p.command()
+}
+
+// do executes the command c and prints the current line if it has a
+// print suffix.
+func (ed *Editor) do(c *command) error {
+	ed.current = c
+	if err := ed.exec(c); err != nil {
 		return err
 	}
 	return ed.display(ed.dot, ed.dot, ed.cs)
 }
 
+// nextLine reads the next line of input for the parser.
+func (ed *Editor) nextLine(text bool) (string, bool) {
+	ed.input.text = text
+	defer func() { ed.input.text = false }()
+	if !ed.input.Scan() {
+		return "", false
+	}
+	if ed.script {
+		ed.lc++
+	}
+	return ed.input.buf, true
+}
+
 func (ed *Editor) Run() {
 	for {
 		err := ed.run()
@@ -229,11 +511,40 @@ func (ed *Editor) Run() {
 		}
 		ed.err = nil
 	}
+	if ed.dryrun {
+		ed.writeChanges()
+	}
+	if ed.rec != nil {
+		ed.rec.flush()
+	}
+}
+
+// writeChanges writes the changes made to the buffer since startup as
+// a unified diff.
+func (ed *Editor) writeChanges() {
+	writeUnified(ed.stdout, ed.orig, ed.file.lines, diff(ed.orig, ed.file.lines), ed.path, ed.path, 3)
+}
+
+// exit terminates the editor with the exit status code. When quitting
+// in dry-run mode the changes made to the buffer are written first. An
+// embedded editor isn't terminated, it returns errQuit instead.
+func (ed *Editor) exit(code int) error {
+	if ed.rec != nil {
+		ed.rec.flush()
+	}
+	if ed.embedded {
+		return errQuit
+	}
+	if ed.dryrun && code == 0 {
+		ed.writeChanges()
+	}
+	os.Exit(code)
+	return nil
 }
 
-func (ed *Editor) getThirdAddr() (int, error) {
+func (ed *Editor) getThirdAddr(dest []address) (int, error) {
 	start, end := ed.first, ed.second
-	if err := ed.parse(); err != nil {
+	if err := ed.resolve(dest); err != nil {
 		return -1, err
 	}
 	if ed.addrc == 0 {
@@ -264,21 +575,33 @@ func (ed *Editor) read(path string) error {
 		if err != nil {
 			return err
 		}
-		ed.file.append(ed.second, lines)
 	} else {
-		buf, err := os.ReadFile(path)
+		lines, err = readFile(ed.fs, path)
 		if err != nil {
-			ed.file = file{path: path}
-			return ErrCannotReadFile
+			if len(ed.file.lines) == 0 {
+				if path != ed.file.path {
+					ed.undo.rename(ed.file.path)
+				}
+				ed.file = file{path: path}
+				ed.undo.store(ed.g)
+			}
+			return err
 		}
-		lines = strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
-		n := min(ed.second, len(ed.file.lines))
-		ed.file = file{
-			lines: append(ed.file.lines[:n], append(lines, ed.file.lines[n:]...)...),
-			path:  path,
+		if path != ed.file.path {
+			ed.undo.rename(ed.file.path)
 		}
-		ed.undo.reset()
+		ed.file.path = path
 	}
+	n := min(ed.second, len(ed.file.lines))
+	if len(lines) > 0 && len(ed.file.lines) > 0 {
+		ed.dirty = true
+	}
+	ed.file.append(n, lines)
+	ed.inserted(n+1, lines)
+	if len(lines) > 0 {
+		ed.undo.append(undoTypeDelete, cursor{first: n + 1, second: n + len(lines), dot: ed.dot}, lines)
+	}
+	ed.undo.store(ed.g)
 	size := len(lines)
 	for _, ln := range lines {
 		size += len(ln)
@@ -290,21 +613,39 @@ func (ed *Editor) read(path string) error {
 	return nil
 }
 
-func (ed *Editor) append(dot int) error {
-	for ed.Scan() {
-		ln := ed.scanString()
-		if ln == "." {
-			break
+// readLines returns the lines of the file path, or the output of the
+// shell command if path starts with '!'.
+func (ed *Editor) readLines(path string) ([]string, error) {
+	if r, _ := utf8.DecodeRuneInString(path); r == '!' {
+		if path[1:] == "" {
+			return nil, ErrNoCmd
 		}
+		return ed.shell(path[1:])
+	}
+	return readFile(ed.fs, path)
+}
+
+// readFile returns the lines of the file path in fsys.
+func readFile(fsys FS, path string) ([]string, error) {
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, ErrCannotReadFile
+	}
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n"), nil
+}
+
+func (ed *Editor) append(dot int, text []string) error {
+	for _, ln := range text {
 		ed.file.append(dot, []string{ln})
 		dot++
 		ed.undo.append(undoTypeDelete, cursor{first: dot, second: dot, dot: ed.dot}, ed.file.lines[dot-1:dot])
 		ed.dot = dot
 		ed.dirty = true
-		if ed.script {
-			ed.lc++
-		}
 	}
+	ed.inserted(dot-len(text)+1, text)
 	ed.undo.store(ed.g)
 	return nil
 }
@@ -314,10 +655,24 @@ func (ed *Editor) delete(start, end int) {
 	copy(lines, ed.file.lines[start-1:end])
 	ed.undo.append(undoTypeAdd, cursor{first: start, second: end, dot: ed.dot}, lines)
 	ed.file.delete(start, end)
+	ed.deleted(start, lines)
 	ed.dot = start - 1
 	ed.dirty = true
 }
 
+// splice replaces the lines start through end with lines and records
+// the change for undo. end may be start-1 to insert without deleting.
+func (ed *Editor) splice(start, end int, lines []string) {
+	if end >= start {
+		ed.delete(start, end)
+	}
+	lines = slices.Clone(lines)
+	ed.file.append(start-1, lines)
+	ed.inserted(start, lines)
+	ed.undo.append(undoTypeDelete, cursor{first: start, second: start + len(lines) - 1, dot: ed.dot}, lines)
+	ed.dirty = true
+}
+
 func (ed *Editor) display(start, end int, flags suffix) error {
 	if flags == 0 {
 		return nil
@@ -325,17 +680,23 @@ func (ed *Editor) display(start, end int, flags suffix) error {
 	if start < 1 {
 		return ErrInvalidAddress
 	}
-	for start--; start != end; start++ {
-		ed.dot = start + 1
+	page := 0
+	if ed.pager && ed.input.le != nil && ed.rows > 1 {
+		page = ed.rows - 1
+	}
+	for i := start - 1; i != end; i++ {
+		if n := i - start + 1; page > 0 && n > 0 && n%page == 0 && !ed.more() {
+			break
+		}
+		ed.dot = i + 1
 		var ln string
 		if flags&suffixEnumerate > 0 {
 			ln = fmt.Sprintf("%d\t", ed.dot)
 		}
 		if flags&suffixList > 0 {
-			quoted := strings.Replace(strconv.QuoteToASCII(ed.file.lines[start]), "$", "\\$", -1)
-			ln += fmt.Sprintf("%s$", quoted[1:len(quoted)-1])
+			ln += ed.listLine(ed.file.lines[i], (len(ln)+7)/8*8)
 		} else {
-			ln += ed.file.lines[start]
+			ln += ed.markMatches(ed.file.lines[i])
 		}
 		fmt.Fprintln(ed.stdout, ln)
 	}
@@ -343,7 +704,51 @@ func (ed *Editor) display(start, end int, flags suffix) error {
 	return nil
 }
 
+// more prompts the user to continue paging and reports whether to
+// proceed, q stops the output.
+func (ed *Editor) more() bool {
+	fmt.Fprint(ed.stdout, "--More--")
+	r, err := ed.input.le.readKey()
+	fmt.Fprint(ed.stdout, "\r        \r")
+	return err == nil && r != 'q' && r != 'Q'
+}
+
+// matches returns the spans of the previous regular expression in ln
+// that should be highlighted.
+func (ed *Editor) matches(ln string) [][]int {
+	if !ed.highlight || ed.re == nil {
+		return nil
+	}
+	var spans [][]int
+	for _, m := range ed.re.FindAllStringIndex(ln, -1) {
+		if m[0] != m[1] {
+			spans = append(spans, m)
+		}
+	}
+	return spans
+}
+
+// markMatches wraps the highlighted spans of ln in ANSI color sequences.
+func (ed *Editor) markMatches(ln string) string {
+	var (
+		sb   strings.Builder
+		prev int
+	)
+	for _, m := range ed.matches(ln) {
+		sb.WriteString(ln[prev:m[0]])
+		sb.WriteString(highlightStart)
+		sb.WriteString(ln[m[0]:m[1]])
+		sb.WriteString(highlightEnd)
+		prev = m[1]
+	}
+	sb.WriteString(ln[prev:])
+	return sb.String()
+}
+
 func (ed *Editor) shell(args string) ([]string, error) {
+	if err := ed.refuseShell(args); err != nil {
+		return nil, err
+	}
 	var sb strings.Builder
 	count := utf8.RuneCountInString(args)
 	for i := 0; i < count; {
@@ -377,161 +782,169 @@ func (ed *Editor) shell(args string) ([]string, error) {
 	return strings.Split(strings.TrimRight(string(output), "\n"), "\n"), err
 }
 
-func (ed *Editor) getSuffix() error {
-	var ok bool
-	r := ed.token()
-	for !ok {
-		r = ed.token()
-		switch r {
-		case 'n':
-			ed.cs |= suffixEnumerate
-			ed.consume()
-		case 'l':
-			ed.cs |= suffixList
-			ed.consume()
-		case 'p':
-			ed.cs |= suffixPrint
-			ed.consume()
-		default:
-			ok = true
-		}
+// refuseShell fails with ErrShellDisabled if shell commands are
+// refused, and remembers the first command that was.
+func (ed *Editor) refuseShell(cmd string) error {
+	if !ed.noshell {
+		return nil
 	}
-	if !ed.input.eof() && r != '\n' {
-		return ErrInvalidCmdSuffix
+	if ed.refused == "" {
+		ed.refused = cmd
 	}
-	return nil
+	return ErrShellDisabled
 }
 
-func (ed *Editor) buildList(g, interactive bool) error {
-	delim := ed.token()
-	if delim == ' ' || delim == '\n' || ed.input.eof() {
-		return ErrInvalidPatternDelim
-	}
-	ed.consume()
-	search, _ := ed.scanStringUntil(delim)
-	if ed.token() == delim {
-		ed.consume()
-	}
-	var (
-		re  *regexp.Regexp
-		err error
-	)
-	if search == "" {
-		if ed.re == nil {
-			return ErrNoPrevPattern
-		}
-		re = ed.re
-	} else {
-		re, err = regexp.Compile(search)
-		if err != nil {
-			return err
-		}
-	}
-	if interactive {
-		if err := ed.getSuffix(); err != nil {
-			return err
+// confirm prints ln with the part between the byte offsets start and end
+// marked and reads the answer to whether it should be replaced: y (yes),
+// n (no), a (all remaining) or q (quit).
+func (ed *Editor) confirm(ln string, start, end int) rune {
+	var sb strings.Builder
+	for _, r := range ln[:start] {
+		if r == '\t' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune(' ')
 		}
 	}
-	ed.list = []int{}
-	for i := ed.first - 1; i < ed.second; i++ {
-		if re.MatchString(ed.file.lines[i]) == g {
-			ed.list = append(ed.list, i+1)
+	sb.WriteString(strings.Repeat("^", max(utf8.RuneCountInString(ln[start:end]), 1)))
+	fmt.Fprintf(ed.stdout, "%s\n%s\n", ln, sb.String())
+	for ed.input.Scan() {
+		if ans := strings.ToLower(strings.TrimSpace(ed.input.buf)); len(ans) == 1 && strings.Contains("ynaq", ans) {
+			return rune(ans[0])
 		}
+		fmt.Fprintln(ed.stdout, ErrDefault)
 	}
-	ed.re = re
-	return nil
+	return 'q'
 }
 
-func (ed *Editor) cmdList() (string, error) {
+func (ed *Editor) substitute(re *regexp.Regexp, replace string, nth int, confirm bool) error {
+	repl, err := compileReplacement(replace, re.NumSubexp(), false)
+	if err != nil {
+		return err
+	}
 	var (
-		sb   strings.Builder
-		done bool
-		ln   string
+		subs, found int
+		quit        bool
 	)
-	if !ed.input.eof() {
-		ln = ed.scanString()
-	}
-	for {
-		done = true
-		if strings.HasSuffix(ln, "\\") {
-			ln = strings.TrimSuffix(ln, "\\")
-			done = false
-			ed.consume()
-		}
-		sb.WriteString(ln)
-		if !done {
-			if !ed.input.Scan() {
-				return "", ErrUnexpectedEOF
-			}
-			ln = ed.input.buf
+	for i := ed.first - 1; i < ed.second && !quit; i++ {
+		ln := ed.file.lines[i]
+		matches := re.FindAllStringSubmatchIndex(ln, -1)
+		if len(matches) == 0 || nth > len(matches) {
 			continue
 		}
-		break
-	}
-	return sb.String(), nil
-}
-
-func (ed *Editor) substitute(re *regexp.Regexp, replace string, nth int) error {
-	var subs int
-	for i := ed.first - 1; i < ed.second; i++ {
-		if !re.MatchString(ed.file.lines[i]) {
-			continue
-		}
-		submatch := re.FindAllStringSubmatch(ed.file.lines[i], -1)
-		matches := re.FindAllStringIndex(ed.file.lines[i], -1)
-		for mi, match := range matches {
+		var (
+			sb      strings.Builder
+			prev    int
+			changed bool
+		)
+		for mi, m := range matches {
 			if nth > 0 && mi != nth-1 {
 				continue
 			}
-			start, end := match[0], match[1]
-			var sb strings.Builder
-			sb.WriteString(ed.file.lines[i][:start])
-			count := utf8.RuneCountInString(replace)
-			for j := 0; j < count; {
-				r, w := utf8.DecodeRuneInString(replace[j:])
-				if j+w < count {
-					next, nw := utf8.DecodeRuneInString(replace[j+w:])
-					if r == '\\' && next == '&' {
-						j += w + nw
-						sb.WriteRune(next)
-						continue
-					} else if r == '\\' && unicode.IsDigit(next) {
-						j += w + nw
-						d, err := strconv.Atoi(string(next))
-						if err != nil || d < 1 || d > len(submatch) {
-							return ErrNumberOutOfRange
-						}
-						if d >= len(submatch[0]) {
-							sb.WriteRune(next)
-						} else if d > 0 {
-							sb.WriteString(submatch[0][d])
-						}
-						continue
-					}
-				}
-				if r == '&' {
-					sb.WriteString(ed.file.lines[i][start:end])
-					j += w
+			sb.WriteString(ln[prev:m[0]])
+			prev = m[0]
+			if confirm {
+				found++
+				switch ed.confirm(sb.String()+ln[m[0]:], sb.Len(), sb.Len()+m[1]-m[0]) {
+				case 'q':
+					quit = true
+				case 'n':
 					continue
+				case 'a':
+					confirm = false
+				}
+				if quit {
+					break
 				}
-				j += w
-				sb.WriteRune(r)
 			}
-			// TODO(thimc): Handle embedded newlines in the replacement string.
-			sb.WriteString(ed.file.lines[i][end:])
-			ed.undo.append(undoTypeAdd, cursor{first: i + 1, second: i + 1, dot: ed.dot}, []string{ed.file.lines[i]})
-			ed.undo.append(undoTypeDelete, cursor{first: i + 1, second: i + 1, dot: ed.dot}, []string{sb.String()})
-			ed.file.lines[i] = sb.String()
-			ed.dirty = true
-			ed.dot = i + 1
-			subs++
+			repl.expand(&sb, ln, m)
+			prev = m[1]
+			changed = true
+		}
+		if !changed {
+			continue
 		}
+		// TODO(thimc): Handle embedded newlines in the replacement string.
+		sb.WriteString(ln[prev:])
+		ed.undo.append(undoTypeAdd, cursor{first: i + 1, second: i + 1, dot: ed.dot}, []string{ln})
+		ed.undo.append(undoTypeDelete, cursor{first: i + 1, second: i + 1, dot: ed.dot}, []string{sb.String()})
+		ed.file.lines[i] = sb.String()
+		ed.deleted(i+1, []string{ln})
+		ed.inserted(i+1, ed.file.lines[i:i+1])
+		ed.dirty = true
+		ed.dot = i + 1
+		subs++
 	}
 	ed.re = re
 	ed.replace = replace
 	if subs == 0 && !ed.g {
+		if found > 0 {
+			return nil // every match was declined
+		}
 		return ErrNoMatch
 	}
 	ed.undo.store(ed.g)
 	return ed.display(ed.dot, ed.dot, ed.cs)
 }
+
+// substituteMulti is the multi-line form of substitute. The pattern is
+// matched against the addressed lines joined by newlines, so a match may
+// span several lines and a \n in the replacement splits a line. nth
+// counts the matches in the whole range.
+func (ed *Editor) substituteMulti(re *regexp.Regexp, replace string, nth int) error {
+	repl, err := compileReplacement(replace, re.NumSubexp(), true)
+	if err != nil {
+		return err
+	}
+	old := ed.file.lines[ed.first-1 : ed.second]
+	text, _ := joinLines(old)
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if nth > len(matches) {
+		matches = nil
+	} else if nth > 0 {
+		matches = matches[nth-1 : nth]
+	}
+	ed.re = re
+	ed.replace = replace
+	if len(matches) == 0 {
+		if ed.g {
+			return nil
+		}
+		return ErrNoMatch
+	}
+	var (
+		sb        strings.Builder
+		prev, end int
+	)
+	for _, m := range matches {
+		sb.WriteString(text[prev:m[0]])
+		repl.expand(&sb, text, m)
+		prev, end = m[1], sb.Len()
+	}
+	last := strings.Count(sb.String()[:end], "\n")
+	sb.WriteString(text[prev:])
+	lines := strings.Split(sb.String(), "\n")
+	chunks := diff(old, lines)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		c := chunks[i]
+		ed.splice(ed.first+c.a0, ed.first+c.a1-1, lines[c.b0:c.b1])
+	}
+	ed.dot = ed.first + last
+	ed.undo.store(ed.g)
+	return ed.display(ed.dot, ed.dot, ed.cs)
+}
+
+// joinLines joins lines with newlines. It also returns the offset of the
+// start of every line in the result, followed by one past the end.
+func joinLines(lines []string) (string, []int) {
+	var sb strings.Builder
+	offs := make([]int, len(lines)+1)
+	for i, ln := range lines {
+		offs[i] = sb.Len()
+		sb.WriteString(ln)
+		sb.WriteByte('\n')
+	}
+	offs[len(lines)] = sb.Len()
+	text := sb.String()
+	return text[:max(len(text)-1, 0)], offs
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	hl := func(s string) string { return highlightStart + s + highlightEnd }
+	tests := []struct {
+		re    string
+		line  string
+		flags suffix
+		want  string
+	}{
+		{re: "b+", line: "abba", flags: suffixPrint, want: "a" + hl("bb") + "a\n"},
+		{re: "a", line: "abba", flags: suffixPrint, want: hl("a") + "bb" + hl("a") + "\n"},
+		{re: "x*", line: "abc", flags: suffixPrint, want: "abc\n"},
+		{re: "c", line: "abc", flags: suffixEnumerate, want: "1\tab" + hl("c") + "\n"},
+		{re: "c", line: "a\tb\tc", flags: suffixList, want: `a\tb\t` + hl("c") + "$\n"},
+		{re: `\t`, line: "a\tb", flags: suffixList, want: "a" + hl(`\t`) + "b$\n"},
+		{re: `\$`, line: "a$b", flags: suffixList | suffixEnumerate, want: "1\ta" + hl(`\$`) + "b$\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.re, func(t *testing.T) {
+			var output bytes.Buffer
+			ed := NewEditor(WithStdout(&output), withBuffer(file{lines: []string{test.line}}))
+			ed.highlight = true
+			ed.re = regexp.MustCompile(test.re)
+			if err := ed.display(1, 1, test.flags); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+		})
+	}
+
+	ed := NewEditor(WithStdout(&bytes.Buffer{}), WithHighlight(true))
+	if ed.highlight {
+		t.Fatal("highlighting enabled for a non-terminal")
+	}
+}
+
+func TestScrollDefault(t *testing.T) {
+	var output bytes.Buffer
+	ed := NewEditor(WithStdout(&output), withBuffer(dummy))
+	ed.rows = 4
+	WithStdin(strings.NewReader("1z"))(ed)
+	if err := ed.run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "A\nB\nC\n"; output.String() != want {
+		t.Fatalf("want %q, got %q", want, output.String())
+	}
+}
+
+func TestPager(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+		dot  int
+	}{
+		{keys: "  ", want: "A\nB\n--More--\r        \rC\nD\n--More--\r        \rE\n", dot: 5},
+		{keys: " q", want: "A\nB\n--More--\r        \rC\nD\n--More--\r        \r", dot: 4},
+		{keys: "", want: "A\nB\n--More--\r        \r", dot: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.keys, func(t *testing.T) {
+			var output bytes.Buffer
+			ed := NewEditor(WithStdout(&output), withBuffer(file{lines: dummy.lines[:5]}), WithPager(true))
+			ed.rows = 3
+			ed.input.le = &lineEditor{fd: -1, r: bufio.NewReader(strings.NewReader(test.keys)), w: &output}
+			if err := ed.display(1, 5, suffixPrint); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+			if ed.dot != test.dot {
+				t.Fatalf("want dot %d, got %d", test.dot, ed.dot)
+			}
+		})
+	}
+}
+
+func TestSmartCase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		fold    bool
+		line    string
+		match   bool
+	}{
+		{pattern: "abc", line: "ABC", match: true},
+		{pattern: "Abc", line: "ABC", match: false},
+		{pattern: "Abc", fold: true, line: "ABC", match: true},
+		{pattern: `\S+`, line: "ABC", match: true},
+		{pattern: `a\p{Lu}`, line: "AB", match: true},
+		{pattern: "ä", line: "Ä", match: true},
+		{pattern: "Ä", line: "ä", match: false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			ed := NewEditor(WithSmartCase(true))
+			re, err := ed.compile(test.pattern, test.fold, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if re.MatchString(test.line) != test.match {
+				t.Fatalf("%q matching %q: want %v", re, test.line, test.match)
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		lines []string
+		out   string
+	}{
+		{name: "empty", data: "", lines: nil, out: ""},
+		{name: "empty line", data: "\n", lines: []string{""}, out: "\n"},
+		{name: "trailing newline", data: "a\nb\n", lines: []string{"a", "b"}, out: "a\nb\n"},
+		{name: "no trailing newline", data: "a\nb", lines: []string{"a", "b"}, out: "a\nb\n"},
+		{name: "trailing empty line", data: "a\n\n", lines: []string{"a", ""}, out: "a\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMemFS()
+			m.WriteFile("in", []byte(test.data))
+			ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(m), WithFile("in"))
+			if !slices.Equal(ed.file.lines, test.lines) {
+				t.Fatalf("want lines %q, got %q", test.lines, ed.file.lines)
+			}
+			WithStdin(strings.NewReader("w out\n"))(ed)
+			if err := ed.run(); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := m.ReadFile("out"); err != nil || string(b) != test.out {
+				t.Fatalf("want %q, got %q (%v)", test.out, b, err)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("a\nb\nc\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	ed := NewEditor(WithStdout(&output), WithStderr(&output), WithFile(path), WithDryRun(true))
+	WithStdin(strings.NewReader("2d\n$a\nd\n.\nw\n"))(ed)
+	ed.Run()
+	want := "6\n6\n--- " + path + "\n+++ " + path + "\n@@ -1,3 +1,3 @@\n a\n-b\n c\n+d\n"
+	if output.String() != want {
+		t.Fatalf("want %q, got %q", want, output.String())
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "a\nb\nc\n" {
+		t.Fatalf("file changed to %q (%v)", b, err)
+	}
+}
+
+func TestTextAddress(t *testing.T) {
+	tests := []struct {
+		script bool
+		input  string
+		want   string
+	}{
+		{input: "100a\n1p\n.\n", want: "?\na\na\n"},
+		{script: true, input: "H\n100a\nx\n.\n", want: "script, line: 3: invalid address\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var output bytes.Buffer
+			ed := NewEditor(WithStdout(&output), WithStderr(&output), withBuffer(file{lines: []string{"a", "b"}}), withEmbedded())
+			ed.script = test.script
+			WithStdin(strings.NewReader(test.input))(ed)
+			for ed.input.pos >= 0 {
+				if err := ed.run(); err != nil && ed.errorln(ed.verbose, err) == errQuit {
+					break
+				}
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+		})
+	}
+}
+
+func TestDryRunShell(t *testing.T) {
+	var output bytes.Buffer
+	ed := NewEditor(WithStdout(&output), WithStderr(&output), withBuffer(file{lines: []string{"a"}}), WithDryRun(true))
+	WithStdin(strings.NewReader("!echo x\nr !echo x\ne !echo x\n"))(ed)
+	for ed.input.pos >= 0 {
+		if err := ed.run(); err != nil {
+			if err != ErrShellDisabled {
+				t.Fatalf("want %q, got %q", ErrShellDisabled, err)
+			}
+			ed.errorln(false, err)
+		}
+	}
+	if want := "?\n?\n?\n"; output.String() != want {
+		t.Fatalf("want %q, got %q", want, output.String())
+	}
+	if !slices.Equal(ed.file.lines, []string{"a"}) {
+		t.Fatalf("buffer changed to %q", ed.file.lines)
+	}
+}
+
+func TestKeepUndo(t *testing.T) {
+	tests := []struct {
+		keep   bool
+		script string
+		buf    []string
+		path   string
+		files  map[string]string // contents of the files afterwards
+		err    error
+	}{
+		{script: "e b\nu", buf: []string{"x"}, path: "b", err: ErrNothingToUndo},
+		{keep: true, script: "e b\nu", buf: []string{"a", "b"}, path: "a"},
+		{keep: true, script: "1d\nE b\nu\nu", buf: []string{"a", "b"}, path: "a"},
+		{keep: true, script: "e c\nu", buf: []string{"a", "b"}, path: "a"},
+		{keep: true, script: "e b\nu\n1d\nw", buf: []string{"b"}, path: "a", files: map[string]string{"a": "b\n", "b": "x\n"}},
+		{keep: true, script: "e b\nu\nlater", buf: []string{"x"}, path: "b"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%t %q", test.keep, test.script), func(t *testing.T) {
+			mem := NewMemFS()
+			mem.WriteFile("a", []byte("a\nb\n"))
+			mem.WriteFile("b", []byte("x\n"))
+			mem.WriteFile("c", nil)
+			ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(mem), WithFile("a"), WithKeepUndo(test.keep))
+			WithStdin(strings.NewReader(test.script))(ed)
+			var err error
+			for err == nil && ed.input.pos >= 0 {
+				err = ed.run()
+			}
+			if err == ErrFileModified {
+				err = nil // end of input
+			}
+			if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if !slices.Equal(ed.file.lines, test.buf) {
+				t.Fatalf("want buffer %q, got %q", test.buf, ed.file.lines)
+			}
+			if ed.file.path != test.path {
+				t.Fatalf("want file name %q, got %q", test.path, ed.file.path)
+			}
+			for name, want := range test.files {
+				if b, _ := mem.ReadFile(name); string(b) != want {
+					t.Fatalf("want file %s %q, got %q", name, want, b)
+				}
+			}
+		})
+	}
+}
@@ -2,22 +2,22 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"regexp"
-	"strconv"
 	"strings"
-	"unicode"
 )
 
-type cmd func(ed *Editor) error
+type cmd func(ed *Editor, c *command) error
 
+// cmds maps command names to their implementations. It's only written
+// by init, so editors running concurrently can share it.
 var cmds map[rune]cmd
 
 func init() {
 	cmds = map[rune]cmd{
 		'a':  cmdAppend,
+		'A':  cmdApply,
 		'c':  cmdChange,
 		'd':  cmdDelete,
+		'D':  cmdDiff,
 		'E':  cmdEdit,
 		'e':  cmdEdit,
 		'f':  cmdFilename,
@@ -49,41 +49,241 @@ func init() {
 		'\n': cmdNone,
 		EOF:  cmdNone,
 	}
+	words = map[string]cmd{
+		"checkpoint": cmdCheckpoint,
+		"diff":       cmdDiffCheckpoint,
+		"earlier":    cmdEarlier,
+		"later":      cmdLater,
+		"restore":    cmdRestore,
+	}
 }
 
-func (ed *Editor) exec() error {
-	ed.skipWhitespace()
-	if cmd, ok := cmds[ed.token()]; ok {
-		return cmd(ed)
+// words maps the names of the built-in commands that are words rather
+// than characters to their implementations. They're parsed like
+// registered commands, so they don't shadow e and l.
+var words map[string]cmd
+
+// exec evaluates the addresses of the command and executes it.
+func (ed *Editor) exec(c *command) error {
+	if err := ed.resolve(c.addrs); err != nil {
+		return err
 	}
-	return ErrUnknownCmd
+	defer func(prev *command) { ed.running = prev }(ed.running)
+	ed.running = c
+	if c.ext != "" {
+		if fn, ok := words[c.ext]; ok {
+			return fn(ed, c)
+		}
+		return ed.runRegistered(c)
+	}
+	fn, ok := cmds[c.name]
+	if !ok {
+		return ErrUnknownCmd
+	}
+	ed.cs |= c.suffix
+	return fn(ed, c)
 }
 
-func cmdAppend(ed *Editor) error {
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
+// checkText checks the addresses of an a, c or i command before its
+// text is read, such that the text isn't lost to an invalid address. The
+// cursor is left as it was, since exec evaluates the addresses again.
+func (ed *Editor) checkText(c *command) error {
+	defer func(cur cursor) { ed.cursor = cur }(ed.cursor)
+	if err := ed.resolve(c.addrs); err != nil {
 		return err
 	}
-	return ed.append(ed.second)
+	if c.name == 'c' {
+		return ed.validate(ed.dot, ed.dot)
+	}
+	return ed.validateAfter(ed.second)
+}
+
+// updateList keeps the lines marked by a global command in step with a
+// change of n lines at the line first. The lines following the change
+// are renumbered and deleted lines are unmarked.
+func (ed *Editor) updateList(first, n int, deleted bool) {
+	list := ed.list[:0]
+	for _, i := range ed.list {
+		switch {
+		case i < first:
+		case deleted && i < first+n:
+			continue
+		case deleted:
+			i -= n
+		default:
+			i += n
+		}
+		list = append(list, i)
+	}
+	ed.list = list
+}
+
+// moveList renumbers the lines marked by a global command after the
+// lines start through end were moved to the line first.
+func moveList(list []int, start, end, first int) []int {
+	n := end - start + 1
+	for k, i := range list {
+		if i >= start && i <= end {
+			i = first + i - start
+		} else {
+			if i > end {
+				i -= n
+			}
+			if i >= first {
+				i += n
+			}
+		}
+		list[k] = i
+	}
+	return list
+}
+
+// resolve evaluates the address list and sets the cursor accordingly.
+func (ed *Editor) resolve(addrs []address) error {
+	var addr int
+	ed.addrc = 0
+	ed.first, ed.second = ed.dot, ed.dot
+	defer func() {
+		ed.addrc = min(ed.addrc, 2)
+		if ed.addrc == 1 || ed.addrc > 0 && ed.second != addr {
+			ed.first = ed.second
+		}
+	}()
+	for i, a := range addrs {
+		var err error
+		addr, err = ed.evalAddress(a)
+		if err != nil {
+			return err
+		}
+		ed.addrc += 1
+		if addr < 1 {
+			ed.second = 0
+			break
+		}
+		ed.first = ed.second
+		ed.second = addr
+		if a.sep == ';' {
+			ed.dot = addr
+		}
+		if a.sep != 0 && i == len(addrs)-1 {
+			addr = -1 // trailing separator
+		}
+	}
+	return nil
+}
+
+// evalAddress returns the line number the address refers to.
+func (ed *Editor) evalAddress(a address) (int, error) {
+	addr := ed.dot
+	for _, t := range a.terms {
+		switch t.kind {
+		case addrNumber:
+			addr = t.n
+		case addrOffset:
+			if t.op == '+' {
+				addr += t.n
+			} else {
+				addr -= t.n
+			}
+		case addrDot:
+			addr = ed.dot
+		case addrLast:
+			addr = len(ed.lines)
+		case addrSearch:
+			var err error
+			if addr, err = ed.search(t); err != nil {
+				return -1, err
+			}
+		case addrMark:
+			addr = ed.mark[t.n]
+			if addr < 1 || addr > len(ed.lines) {
+				return -1, ErrInvalidAddress
+			}
+		case addrRange:
+			ed.addrc += 1
+			ed.second = 1
+			if t.op == ';' {
+				ed.second = ed.dot
+			}
+			addr = len(ed.lines)
+			if t.next != nil {
+				if n, err := ed.evalAddress(*t.next); err == nil {
+					addr = n
+				}
+			}
+		}
+	}
+	if addr < 0 || addr > len(ed.lines) {
+		ed.addrc += 1
+		return -1, ErrInvalidAddress
+	}
+	return addr, nil
 }
 
-func cmdChange(ed *Editor) error {
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
+// search returns the next line, or the previous one for ?re?, that
+// matches the pattern of the term. The search wraps around the buffer.
+func (ed *Editor) search(t addrTerm) (int, error) {
+	if len(ed.lines) < 1 {
+		return -1, ErrNoMatch
+	}
+	re, err := ed.compile(t.pattern, t.fold, t.multi)
+	if err != nil {
+		return -1, err
+	}
+	ed.re = re
+	match := func(i int) bool { return re.MatchString(ed.lines[i]) }
+	if t.multi {
+		text, offs := joinLines(ed.lines)
+		match = func(i int) bool {
+			m := re.FindStringIndex(text[offs[i]:])
+			return m != nil && offs[i]+m[0] < offs[i+1]
+		}
+	}
+	for n, i := 0, ed.dot-1; n < len(ed.lines); n++ {
+		if t.op == '/' {
+			i++
+		} else {
+			i--
+			if i < 0 {
+				i = len(ed.lines) - 1
+			}
+		}
+		i %= len(ed.lines)
+		if match(i) {
+			return i + 1, nil
+		}
+	}
+	return -1, ErrNoMatch
+}
+
+func cmdAppend(ed *Editor, c *command) error {
+	if err := ed.validateAfter(ed.second); err != nil {
 		return err
 	}
-	if err := ed.validate(ed.dot, ed.dot); err != nil {
+	return ed.append(ed.second, c.text)
+}
+
+func cmdApply(ed *Editor, c *command) error {
+	lines, err := ed.readLines(c.arg)
+	if err != nil {
 		return err
 	}
-	ed.delete(ed.first, ed.second)
-	return ed.append(ed.dot)
+	hunks, err := parsePatch(lines)
+	if err != nil {
+		return err
+	}
+	return ed.patch(hunks)
 }
 
-func cmdDelete(ed *Editor) error {
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
+func cmdChange(ed *Editor, c *command) error {
+	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
+	ed.delete(ed.first, ed.second)
+	return ed.append(ed.dot, c.text)
+}
+
+func cmdDelete(ed *Editor, c *command) error {
 	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
@@ -95,41 +295,71 @@ func cmdDelete(ed *Editor) error {
 	return nil
 }
 
-func cmdEdit(ed *Editor) error {
-	r := ed.token()
-	ed.consume()
-	if ed.dirty && r == 'e' {
-		ed.dirty = false
-		return ErrFileModified
-	}
+func cmdDiff(ed *Editor, c *command) error {
 	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	} else if !unicode.IsSpace(ed.token()) {
-		return ErrUnexpectedCmdSuffix
+		if err := ed.validate(1, len(ed.file.lines)); err != nil {
+			return err
+		}
 	}
-	// if err := ed.getSuffix(); err != nil {
-	// 	return err
-	// }
-	ed.skipWhitespace()
-	ed.delete(1, len(ed.file.lines))
-	return ed.read(ed.scanString())
+	path, err := ed.validatePath(c.arg)
+	if err != nil {
+		return err
+	}
+	lines, err := ed.readLines(path)
+	if err != nil {
+		return err
+	}
+	var chunks []diffChunk
+	for _, ch := range diff(lines, ed.file.lines) {
+		if ed.addrc == 0 || ch.touches(ed.first, ed.second) {
+			chunks = append(chunks, ch)
+		}
+	}
+	if c.flag == 'e' {
+		writeEdScript(ed.stdout, ed.file.lines, chunks)
+	} else {
+		writeUnified(ed.stdout, lines, ed.file.lines, chunks, path, path, 3)
+	}
+	return nil
 }
 
-func cmdFilename(ed *Editor) error {
-	ed.consume()
+func cmdEarlier(ed *Editor, c *command) error {
 	if ed.addrc > 0 {
 		return ErrUnexpectedAddress
 	}
-	if !unicode.IsSpace(ed.token()) && !ed.input.eof() {
-		return ErrUnexpectedCmdSuffix
+	n, d, err := parseTimeOffset(strings.TrimSpace(c.arg))
+	if err != nil {
+		return err
+	}
+	return ed.undo.earlier(ed, n, d)
+}
+
+func cmdEdit(ed *Editor, c *command) error {
+	if ed.dirty && c.name == 'e' {
+		ed.dirty = false
+		return ErrFileModified
+	}
+	if strings.HasPrefix(c.arg, "!") {
+		if err := ed.refuseShell(c.arg[1:]); err != nil {
+			return err // before the buffer is lost
+		}
 	}
-	ed.skipWhitespace()
-	path := ed.scanString()
-	if len(path) > 0 && path[0] == '!' {
-		return ErrInvalidRedirection
+	ed.delete(1, len(ed.file.lines))
+	err := ed.read(c.arg)
+	if ed.keepundo {
+		ed.undo.store(ed.g)
+	} else {
+		ed.undo.reset()
+		if err == nil && ed.undofile {
+			ed.loadUndo()
+		}
 	}
-	var err error
-	path, err = ed.validatePath(path)
+	ed.dirty = false
+	return err
+}
+
+func cmdFilename(ed *Editor, c *command) error {
+	path, err := ed.validatePath(c.arg)
 	if err != nil {
 		return err
 	}
@@ -138,40 +368,38 @@ func cmdFilename(ed *Editor) error {
 	return nil
 }
 
-func cmdGlobal(ed *Editor) error {
-	var err error
-	r := ed.token()
-	ed.consume()
+func cmdGlobal(ed *Editor, c *command) error {
 	var (
-		interactive = (r == 'G' || r == 'V')
-		g           = (r == 'g' || r == 'G')
-		cmdlist     string
+		g           = c.global
+		interactive = (c.name == 'G' || c.name == 'V')
+		list        = g.list
 	)
 	if ed.g {
 		return ErrCannotNestGlobal
 	} else if err := ed.validate(1, len(ed.file.lines)); err != nil {
 		return err
-	} else if err := ed.buildList(g, interactive); err != nil {
+	}
+	re, err := ed.compile(g.pattern, g.fold, false)
+	if err != nil {
 		return err
 	}
-	ed.g = true
-	if !interactive {
-		cmdlist, err = ed.cmdList()
-		if err != nil {
-			return err
-		}
-		if cmdlist == "" {
-			cmdlist = "p"
+	ed.list = []int{}
+	for i := ed.first - 1; i < ed.second; i++ {
+		if re.MatchString(ed.file.lines[i]) == (c.name == 'g' || c.name == 'G') {
+			ed.list = append(ed.list, i+1)
 		}
 	}
+	ed.re = re
+	ed.g = true
 	defer func() {
 		ed.g = false
+		ed.list = nil
 		ed.undo.storeGlobal()
 	}()
 	gs := ed.cs
-	nl := len(ed.file.lines)
-	for _, i := range ed.list {
-		ed.dot = i - (nl - len(ed.file.lines))
+	for len(ed.list) > 0 {
+		ed.dot = ed.list[0]
+		ed.list = ed.list[1:]
 		if interactive {
 			if gs == 0 {
 				gs |= suffixPrint
@@ -182,82 +410,64 @@ func cmdGlobal(ed *Editor) error {
 			if !ed.input.Scan() {
 				return ErrUnexpectedEOF
 			}
-			cmdlist, err = ed.cmdList()
+			p := &parser{more: ed.nextLine, custom: ed.registered}
+			p.doInput(ed.input.buf)
+			cmdlist, err := p.cmdList()
 			if err != nil {
 				return err
 			}
 			if cmdlist == "" {
 				continue
 			} else if cmdlist == "&" {
-				if ed.gcmd == "" {
+				if ed.gcmd == nil {
 					return ErrNoPreviousCmd
 				}
-				cmdlist = ed.gcmd
+				list = ed.gcmd
+			} else if list, err = p.list(cmdlist); err != nil {
+				return err
 			}
 		}
-		ed.doInput(cmdlist)
-		if err := ed.parse(); err != nil {
-			return err
-		}
-		if err := ed.exec(); err != nil {
-			return err
+		for _, gc := range list {
+			if err := ed.exec(gc); err != nil {
+				return err
+			}
 		}
 		if err := ed.display(ed.dot, ed.dot, ed.cs); err != nil {
 			return err
 		}
 		if interactive {
-			ed.gcmd = cmdlist
+			ed.gcmd = list
 		}
 	}
 	ed.cs = 0
 	return nil
 }
 
-func cmdHelp(ed *Editor) error {
-	r := ed.token()
-	ed.consume()
-	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
-	ed.consume()
-	if r == 'H' {
+func cmdHelp(ed *Editor, c *command) error {
+	if c.name == 'H' {
 		ed.verbose = !ed.verbose
-		if ed.verbose {
-			ed.input.insert('h')
-		} else {
-			ed.input.insert(r)
-		}
-	}
-	if r == 'h' {
-		ed.input.insert(r)
 	}
 	return ed.err
 }
 
-func cmdInsert(ed *Editor) error {
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
+func cmdInsert(ed *Editor, c *command) error {
+	if err := ed.validateAfter(ed.second); err != nil {
 		return err
 	}
-	return ed.append(max(ed.second-1, 0))
+	return ed.append(max(ed.second-1, 0), c.text)
 }
 
-func cmdJoin(ed *Editor) error {
-	ed.consume()
+func cmdJoin(ed *Editor, c *command) error {
 	if err := ed.validate(ed.dot, ed.dot+1); err != nil {
 		return err
 	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
 	if ed.first != ed.second {
 		lines := make([]string, ed.second-ed.first+1)
 		copy(lines, ed.file.lines[ed.first-1:ed.second])
 		ed.undo.append(undoTypeAdd, cursor{first: ed.first, second: ed.second + len(lines) - 1, dot: ed.dot}, lines)
 		ed.file.join(ed.first, ed.second)
+		ed.deleted(ed.first, lines)
+		ed.inserted(ed.first, ed.file.lines[ed.first-1:ed.first])
 		ed.undo.append(undoTypeDelete, cursor{first: ed.first, second: ed.first, dot: ed.dot}, nil)
 		ed.dot = ed.second
 		ed.dirty = true
@@ -266,79 +476,63 @@ func cmdJoin(ed *Editor) error {
 	return nil
 }
 
-func cmdMark(ed *Editor) error {
-	ed.consume()
-	if ed.second == 0 {
-		return ErrInvalidAddress
+func cmdLater(ed *Editor, c *command) error {
+	if ed.addrc > 0 {
+		return ErrUnexpectedAddress
 	}
-	r := ed.token()
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
+	n, d, err := parseTimeOffset(strings.TrimSpace(c.arg))
+	if err != nil {
 		return err
 	}
-	if !unicode.IsLower(r) || int(r-'a') >= len(ed.mark) {
-		return ErrInvalidMark
+	return ed.undo.later(ed, n, d)
+}
+
+func cmdMark(ed *Editor, c *command) error {
+	if ed.second == 0 {
+		return ErrInvalidAddress
 	}
-	ed.mark[r-'a'] = ed.second
+	ed.mark[c.mark-'a'] = ed.second
 	return nil
 }
 
-func cmdPrint(ed *Editor) error {
+func cmdPrint(ed *Editor, c *command) error {
 	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
-	switch ed.token() {
-	case 'l':
-		ed.cs |= suffixList
-	case 'n':
-		ed.cs |= suffixEnumerate
-	case 'p':
-		ed.cs |= suffixPrint
-	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
 	return ed.display(ed.first, ed.second, ed.cs)
 }
 
-func cmdMove(ed *Editor) error {
-	ed.consume()
+func cmdMove(ed *Editor, c *command) error {
 	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
-	addr, err := ed.getThirdAddr()
+	addr, err := ed.getThirdAddr(c.dest)
 	if err != nil {
 		return err
 	}
 	if ed.first <= addr && addr < ed.second {
 		return ErrInvalidDestination
 	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
 
 	lines := make([]string, ed.second-ed.first+1)
 	copy(lines, ed.file.lines[ed.first-1:ed.second])
-	ed.undo.append(undoTypeAdd, cursor{first: ed.first, second: ed.first + len(lines) - 1, dot: ed.dot}, lines)
+	ed.undo.append(undoTypeAdd, cursor{first: ed.first, second: ed.second, dot: ed.dot}, lines)
 
 	ed.dot = ed.file.move(ed.first, ed.second, addr)
+	first := ed.dot - len(lines) + 1
+	list := ed.list
+	ed.list = nil // moved lines stay marked
+	ed.deleted(ed.first, lines)
+	ed.inserted(first, lines)
+	ed.list = moveList(list, ed.first, ed.second, first)
+	ed.undo.append(undoTypeDelete, cursor{first: first, second: ed.dot, dot: ed.dot}, lines)
 
-	ulines := make([]string, len(lines))
-	copy(ulines, ed.file.lines[addr-len(lines):addr])
-	ed.undo.append(undoTypeDelete, cursor{first: addr - ed.first + 1, second: addr - ed.first + len(ulines), dot: addr}, ulines)
-
+	ed.dirty = true
 	ed.undo.store(ed.g)
 	return nil
 }
 
-func cmdPrompt(ed *Editor) error {
-	ed.consume()
-	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
+func cmdPrompt(ed *Editor, c *command) error {
 	if ed.up == "" {
 		ed.up = DefaultPrompt
 	}
@@ -346,170 +540,71 @@ func cmdPrompt(ed *Editor) error {
 	return nil
 }
 
-func cmdQuit(ed *Editor) error {
-	r := ed.token()
-	ed.consume()
-	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
-	if r == 'q' && ed.dirty {
+func cmdQuit(ed *Editor, c *command) error {
+	if c.name == 'q' && ed.dirty {
 		ed.dirty = false
 		return ErrFileModified
 	}
-	os.Exit(0)
-	return nil
+	return ed.exit(0)
 }
 
-func cmdRead(ed *Editor) error {
-	ed.consume()
-	if !unicode.IsSpace(ed.token()) && !ed.input.eof() {
-		return ErrUnexpectedCmdSuffix
-	} else if ed.addrc == 0 {
+func cmdRead(ed *Editor, c *command) error {
+	if ed.addrc == 0 {
 		ed.second = len(ed.file.lines)
 	}
-	ed.skipWhitespace()
-	path, err := ed.validatePath(ed.scanString())
+	path, err := ed.validatePath(c.arg)
 	if err != nil {
 		return err
 	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
 	return ed.read(path)
 }
 
-func cmdSubstitute(ed *Editor) error {
-	var err error
-	ed.consume()
-	nth, sflags := 1, 0
-	const (
-		subRepeatLast = 2 << iota
-		subComplementGlobal
-		subComplementPrint
-		subLastRegex
-	)
-	for {
-		r := ed.token()
-		switch {
-		case r == '\n', ed.input.eof():
-			sflags |= subRepeatLast
-			ed.consume()
-		case r == 'g':
-			sflags |= subComplementGlobal
-			ed.consume()
-		case r == 'p':
-			sflags |= subComplementPrint
-			ed.consume()
-		case r == 'r':
-			sflags |= subLastRegex
-			ed.consume()
-		case unicode.IsDigit(r):
-			nth, err = strconv.Atoi(string(r))
-			if err != nil {
-				return err
-			}
-			sflags |= subRepeatLast
-			ed.consume()
-		default:
-			if sflags > 0 {
-				return ErrInvalidCmdSuffix
-			}
-		}
-		if sflags < 1 || r == '\n' || ed.input.eof() {
-			break
-		}
-	}
-	if sflags > 0 && ed.re == nil {
+func cmdSubstitute(ed *Editor, c *command) error {
+	s := c.sub
+	if s.repeat && ed.re == nil {
+		return ErrNoPrevPattern
+	} else if s.pattern == "" && ed.re == nil {
 		return ErrNoPrevPattern
-	}
-	delim := ed.token()
-	ed.consume()
-	re := ed.re
-	if sflags&subLastRegex == 0 {
-		search, eof := ed.scanStringUntil(delim)
-		if !eof && ed.token() == delim {
-			ed.consume()
-		}
-		if search == "" {
-			if ed.re == nil {
-				return ErrNoPrevPattern
-			}
-		} else {
-			re, err = regexp.Compile(search)
-			if err != nil {
-				return err
-			}
-		}
 	}
 	replace := ed.replace
-	var eof bool
-	if sflags == 0 {
-		replace, eof = ed.scanStringUntil(delim)
+	if !s.repeat {
+		replace = s.replace
 		if replace == "%" {
 			if ed.replace == "" {
 				return ErrNoPreviousSub
 			}
 			replace = ed.replace
 		}
-		if !eof {
-			ed.consume()
-		} else {
-			sflags |= subComplementPrint
-		}
 	}
-	if sflags&subComplementGlobal > 0 {
-		nth = -1
-	}
-	if sflags&subComplementPrint > 0 {
+	if s.print || s.open {
 		ed.cs |= suffixPrint
 		ed.cs &= ^(suffixList | suffixEnumerate)
 	}
-	for !eof {
-		r := ed.token()
-		switch {
-		case r == 'g':
-			nth = -1
-			ed.consume()
-			continue
-		case unicode.IsDigit(r):
-			nth, err = strconv.Atoi(string(r))
-			if err != nil {
-				return err
-			}
-			sflags |= subRepeatLast
-			ed.consume()
-			continue
-		default:
-			if err := ed.getSuffix(); err != nil {
-				return err
-			}
-		}
-		break
+	re, err := ed.compile(s.pattern, s.fold, s.multi)
+	if err != nil {
+		return err
 	}
 	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
-	return ed.substitute(re, replace, nth)
+	if s.multi {
+		return ed.substituteMulti(re, replace, s.nth)
+	}
+	return ed.substitute(re, replace, s.nth, s.confirm)
 }
 
-func cmdTransfer(ed *Editor) error {
-	ed.consume()
+func cmdTransfer(ed *Editor, c *command) error {
 	if err := ed.validate(ed.dot, ed.dot); err != nil {
 		return err
 	}
-	addr, err := ed.getThirdAddr()
+	addr, err := ed.getThirdAddr(c.dest)
 	if err != nil {
 		return err
 	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
 	lines := make([]string, ed.second-ed.first+1)
 	copy(lines, ed.file.lines[ed.first-1:ed.second])
 	lc := ed.file.yank(ed.first, ed.second, addr)
+	ed.inserted(addr+1, lines)
 	ed.undo.append(undoTypeDelete, cursor{first: addr + 1, second: addr + len(lines), dot: ed.dot}, lines)
 	ed.second = lc
 	ed.dot = addr + lc
@@ -518,34 +613,12 @@ func cmdTransfer(ed *Editor) error {
 	return nil
 }
 
-func cmdUndo(ed *Editor) error {
-	ed.consume()
-	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
+func cmdUndo(ed *Editor, c *command) error {
 	return ed.undo.pop(ed)
 }
 
-func cmdWrite(ed *Editor) error {
-	r := ed.token()
-	ed.consume()
-	quit := ed.token()
-	if quit == 'q' || quit == 'Q' {
-		ed.consume()
-	}
-	if !unicode.IsSpace(ed.token()) && !ed.input.eof() {
-		return ErrUnexpectedCmdSuffix
-	}
-	ed.skipWhitespace()
-	path := ""
-	if !ed.input.eof() && ed.token() != '\n' {
-		path = ed.scanString()
-	}
-	var err error
-	path, err = ed.validatePath(path)
+func cmdWrite(ed *Editor, c *command) error {
+	path, err := ed.validatePath(c.arg)
 	if err != nil {
 		return err
 	}
@@ -554,19 +627,24 @@ func cmdWrite(ed *Editor) error {
 	} else if err := ed.validate(1, len(ed.file.lines)); err != nil {
 		return err
 	}
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
-	siz, err := ed.file.write(path, r, ed.first, ed.second)
-	if err != nil {
-		return err
+	siz := len(ed.file.contents(ed.first, ed.second))
+	if !ed.dryrun {
+		if siz, err = ed.file.write(ed.fs, path, c.name, ed.first, ed.second); err != nil {
+			return err
+		}
 	}
+	ed.written += siz
 	if !ed.silent {
 		fmt.Fprintln(ed.stdout, siz)
 	}
-	if quit == 'Q' {
-		os.Exit(0)
-	} else if quit == 'q' && ed.dirty {
+	if ed.undofile && !ed.dryrun && c.name == 'w' && ed.first <= 1 && ed.second == len(ed.file.lines) && !strings.HasPrefix(path, "!") {
+		if err := ed.saveUndo(path); err != nil {
+			return err
+		}
+	}
+	if c.flag == 'Q' {
+		return ed.exit(0)
+	} else if c.flag == 'q' && ed.dirty {
 		ed.dirty = false
 		return ErrFileModified
 	}
@@ -574,31 +652,24 @@ func cmdWrite(ed *Editor) error {
 	return nil
 }
 
-func cmdScroll(ed *Editor) error {
-	ed.consume()
+func cmdScroll(ed *Editor, c *command) error {
 	ed.first = 1
 	if err := ed.validate(ed.first, ed.dot+1); err != nil {
 		return err
-	} else if unicode.IsDigit(ed.token()) {
-		n, err := ed.scanNumber()
-		if err != nil {
-			return err
-		}
-		ed.scroll = n
 	}
-	ed.cs = suffixPrint
-	if err := ed.getSuffix(); err != nil {
-		return err
+	if c.count >= 0 {
+		ed.scroll = c.count
+	}
+	ed.cs = suffixPrint | c.suffix
+	scroll := ed.scroll
+	if scroll == 0 {
+		// Leave the last row for the prompt: the end line is inclusive.
+		scroll = max(ed.rows-2, 0)
 	}
-	scroll := min(ed.second+ed.scroll, len(ed.file.lines))
-	return ed.display(ed.second, scroll, ed.cs)
+	return ed.display(ed.second, min(ed.second+scroll, len(ed.file.lines)), ed.cs)
 }
 
-func cmdLineCount(ed *Editor) error {
-	ed.consume()
-	if err := ed.getSuffix(); err != nil {
-		return err
-	}
+func cmdLineCount(ed *Editor, c *command) error {
 	n := ed.second
 	if ed.addrc < 1 {
 		n = len(ed.file.lines)
@@ -607,17 +678,8 @@ func cmdLineCount(ed *Editor) error {
 	return nil
 }
 
-func cmdShell(ed *Editor) error {
-	ed.consume()
-	if ed.addrc > 0 {
-		return ErrUnexpectedAddress
-	}
-	if ed.input.eof() || ed.token() == '\n' {
-		return ErrNoCmd
-	}
-	ed.skipWhitespace()
-	cmd := ed.scanString()
-	output, err := ed.shell(cmd)
+func cmdShell(ed *Editor, c *command) error {
+	output, err := ed.shell(c.arg)
 	if err != nil {
 		return err
 	}
@@ -626,7 +688,7 @@ func cmdShell(ed *Editor) error {
 	return nil
 }
 
-func cmdNone(ed *Editor) error {
+func cmdNone(ed *Editor, c *command) error {
 	ed.first = 1
 	if err := ed.validate(ed.first, ed.dot+1); err != nil {
 		return err
@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"io"
+	"io/fs"
 	"os/exec"
+	"path"
 	"regexp/syntax"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -17,7 +20,7 @@ var (
 			"N", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
 		},
 		mark: [25]int{3, 0},
-		path: "#dummy",
+		path: "dummy",
 	}
 	subBuffer = file{
 		lines: []string{
@@ -37,24 +40,25 @@ func withBuffer(f file) Option {
 	return func(ed *Editor) { ed.file, ed.dot = f, len(f.lines) }
 }
 
+// A noAccessFS is a MemFS in which files named no-access can't be
+// opened for writing.
+type noAccessFS struct{ *MemFS }
+
+func (m noAccessFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	if path.Base(name) == "no-access" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return m.MemFS.OpenFile(name, flag, perm)
+}
+
 func TestEditor(t *testing.T) {
 	dlines := make([]string, len(dummy.lines))
 	copy(dlines, dummy.lines)
 	slines := make([]string, len(subBuffer.lines))
 	copy(slines, subBuffer.lines)
 
-	tmp, err := os.CreateTemp(os.TempDir(), "ed")
-	if err != nil {
-		panic(err)
-	}
-	if _, err := tmp.WriteString(strings.Join(dummy.lines, "\n")); err != nil {
-		panic(err)
-	}
-	if err := tmp.Close(); err != nil {
-		panic(err)
-	}
-	defer os.Remove(tmp.Name())
-	dummy.path = tmp.Name()
+	mem := noAccessFS{NewMemFS()}
+	mem.WriteFile(dummy.path, []byte(strings.Join(dummy.lines, "\n")))
 
 	lc := len(dummy.lines)
 	slc := len(subBuffer.lines)
@@ -79,8 +83,8 @@ func TestEditor(t *testing.T) {
 		{cmd: "d", cur: cursor{first: lc, second: lc, dot: lc - 1}},
 
 		// e / E - edit
-		{cmd: fmt.Sprintf("e %s", tmp.Name()), cur: cursor{first: lc, second: lc, dot: lc * 2}, output: fmt.Sprintf("%d\n", lc*2)},
-		{cmd: fmt.Sprintf("E %s", tmp.Name()), cur: cursor{first: lc * 2, second: lc * 2, dot: lc * 3}, output: fmt.Sprintf("%d\n", lc*2), keep: true},
+		{cmd: fmt.Sprintf("e %s", dummy.path), cur: cursor{first: lc, second: lc, dot: lc * 2}, output: fmt.Sprintf("%d\n", lc*2)},
+		{cmd: fmt.Sprintf("E %s", dummy.path), cur: cursor{first: lc * 2, second: lc * 2, dot: lc * 3}, output: fmt.Sprintf("%d\n", lc*2), keep: true},
 
 		// f - file name
 		{cmd: "f", cur: cursor{first: lc, second: lc, dot: lc}, output: dummy.path + "\n"},
@@ -90,6 +94,13 @@ func TestEditor(t *testing.T) {
 		{cmd: "g/.*/", cur: cursor{first: lc, second: lc, dot: lc}, output: strings.Join(dummy.lines, "\n") + "\n"},
 		{cmd: "g/.*/p", cur: cursor{first: lc, second: lc, dot: lc}, output: strings.Join(dummy.lines, "\n") + "\n"},
 		{cmd: "v/A/p", cur: cursor{first: lc, second: lc, dot: lc}, output: strings.Join(dummy.lines[1:], "\n") + "\n"},
+		{cmd: "g/[AB]/s/$/!/\\\na\\\nnew", cur: cursor{first: 3, second: 3, dot: 4}, buf: append([]string{"A!", "new", "B!", "new"}, dummy.lines[2:]...)},
+		{cmd: ",c\nd\nd\nb\n.", cur: cursor{first: 1, second: lc, dot: 3, addrc: 2}, buf: []string{"d", "d", "b"}},
+		{cmd: "g/d/.,+1j\\\na\\\nX\\\n.", cur: cursor{first: 2, second: 2, dot: 3}, buf: []string{"dd", "b", "X"}, keep: true},
+		{cmd: "g/[A-C]/m0", cur: cursor{first: 3, second: 3, dot: 1, addrc: 1}, buf: append([]string{"C", "B", "A"}, dummy.lines[3:]...)},
+		{cmd: "g/[XY]/.,+1m0", cur: cursor{first: 2, second: 3, dot: 2, addrc: 1}, buf: append(append([]string{"Y", "A", "X"}, dummy.lines[1:23]...), "Z")},
+		{cmd: "g/b/Ip", cur: cursor{first: 4, second: 4, dot: 4}, output: "B B B B B\nB B B B B\n", sub: true},
+		{cmd: "v/[abc]/In", cur: cursor{first: slc, second: slc, dot: slc}, output: "7\tD D D D D\n8\tD D D D D\n", sub: true},
 		{cmd: "G/A.*/npl\np\np", cur: cursor{first: 2, second: 2, dot: 2}, output: "1\tA A A A A$\nA A A A A\n2\tA A A A A$\nA A A A A\n", sub: true},
 		{cmd: "G/.*/\nn\n&\n&\n&\n&\n&\n&\n&\n", cur: cursor{first: slc, second: slc, dot: slc}, output: "A A A A A\n1\tA A A A A\nA A A A A\n2\tA A A A A\nB B B B B\n3\tB B B B B\nB B B B B\n4\tB B B B B\nC C C C C\n5\tC C C C C\nC C C C C\n6\tC C C C C\nD D D D D\n7\tD D D D D\nD D D D D\n8\tD D D D D\n", sub: true},
 		{cmd: "V/A/\nn\n&\n&\n&\n&\n&\n&\n&\n", cur: cursor{first: slc, second: slc, dot: slc}, output: "B B B B B\n3\tB B B B B\nB B B B B\n4\tB B B B B\nC C C C C\n5\tC C C C C\nC C C C C\n6\tC C C C C\nD D D D D\n7\tD D D D D\nD D D D D\n8\tD D D D D\n", sub: true},
@@ -117,6 +128,9 @@ func TestEditor(t *testing.T) {
 		{cmd: ",p", cur: cursor{first: 1, second: lc, dot: lc, addrc: 2}, output: strings.Join(dummy.lines, "\n") + "\n"},
 		{cmd: "n", cur: cursor{first: lc, second: lc, dot: lc}, output: fmt.Sprintf("%d\tZ\n", len(dummy.lines))},
 		{cmd: "l", cur: cursor{first: lc, second: lc, dot: lc}, output: "Z$\n"},
+		{cmd: "2p", cur: cursor{first: 2, second: 2, dot: 2, addrc: 1}, output: "A A A A A\n", sub: true},
+		{cmd: "/B/n", cur: cursor{first: 3, second: 3, dot: 3, addrc: 1}, output: "3\tB B B B B\n", keep: true, sub: true},
+		{cmd: "?A?n", cur: cursor{first: 2, second: 2, dot: 2, addrc: 1}, output: "2\tA A A A A\n", keep: true, sub: true},
 
 		// P - prompt toggle
 		{cmd: "P", cur: cursor{first: lc, second: lc, dot: lc}},
@@ -129,8 +143,10 @@ func TestEditor(t *testing.T) {
 
 		// r - read
 		{cmd: "r", cur: cursor{first: lc, second: lc, dot: lc * 2}, output: fmt.Sprintf("%d\n", lc*2), buf: append(dummy.lines, dummy.lines...)},
-		{cmd: fmt.Sprintf("r %s", tmp.Name()), cur: cursor{first: lc, second: lc, dot: lc * 2}, output: fmt.Sprintf("%d\n", lc*2)},
+		{cmd: fmt.Sprintf("r %s", dummy.path), cur: cursor{first: lc, second: lc, dot: lc * 2}, output: fmt.Sprintf("%d\n", lc*2)},
+		{cmd: "u", cur: cursor{first: lc * 2, second: lc * 2, dot: lc}, buf: dummy.lines, keep: true},
 		{cmd: "r !echo ab", cur: cursor{first: lc, second: lc, dot: lc + 1}, buf: append(dummy.lines, []string{"ab"}...), output: "3\n"},
+		{cmd: "u", cur: cursor{first: lc + 1, second: lc + 1, dot: lc}, buf: dummy.lines, keep: true},
 
 		// s - substitute
 		{cmd: ",s/A/X/gp", cur: cursor{first: 1, second: slc, dot: 2, addrc: 2}, output: "X X X X X\n", buf: []string{"X X X X X", "X X X X X", "B B B B B", "B B B B B", "C C C C C", "C C C C C", "D D D D D", "D D D D D"}, sub: true},
@@ -145,6 +161,30 @@ func TestEditor(t *testing.T) {
 		{cmd: ",s/B.*/test", cur: cursor{first: 1, second: slc, dot: 4, addrc: 2}, output: "test\n", sub: true},
 		{cmd: ",s/B.*/test/", cur: cursor{first: 1, second: slc, dot: 4, addrc: 2}, sub: true},
 
+		{cmd: `1s/(A) (A)/\l\1-\L\2X\Ey/`, cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"a-axy A A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: `1s/A/\uäb/`, cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"Äb A A A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: `3s/B B/\Lx&\u&/`, cur: cursor{first: 3, second: 3, dot: 3, addrc: 1}, buf: append(append([]string{}, subBuffer.lines[:2]...), append([]string{"xb bB b B B B"}, subBuffer.lines[3:]...)...), sub: true},
+		{cmd: `1s/A/\Uéx\Ey/`, cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"ÉXy A A A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: ",s/a/x/gI", cur: cursor{first: 1, second: slc, dot: 2, addrc: 2}, buf: append([]string{"x x x x x", "x x x x x"}, subBuffer.lines[2:]...), sub: true},
+		{cmd: "1s/a/x/2I", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"A x A A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: "1s/a/x/", cur: cursor{first: 1, second: 1, dot: slc, addrc: 1}, err: ErrNoMatch, output: defaultErr, sub: true},
+		{cmd: "1s/A/AAA/g", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"AAA AAA AAA AAA AAA"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: "1s/A/x/12", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"AAA AAA AAA AAx AAA"}, subBuffer.lines[1:]...), keep: true, sub: true},
+		{cmd: "s13", cur: cursor{first: 1, second: 1, dot: 1}, buf: append([]string{"AAA AAA AAA AAx AxA"}, subBuffer.lines[1:]...), keep: true, sub: true},
+		{cmd: "1s/A/B/2", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"A B A A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: `1s/(\w) (\w)/\2\1/g`, cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, buf: append([]string{"BA AA A"}, subBuffer.lines[1:]...), keep: true, sub: true},
+		{cmd: `1,3s/A\nB/x/M`, cur: cursor{first: 1, second: 3, dot: 1, addrc: 2}, buf: append([]string{"x"}, dummy.lines[2:]...)},
+		{cmd: `1,2s/\n//M`, cur: cursor{first: 1, second: 2, dot: 1, addrc: 2}, buf: append([]string{"AB"}, dummy.lines[2:]...)},
+		{cmd: "u", cur: cursor{first: 1, second: 1, dot: lc}, buf: dummy.lines, keep: true},
+		{cmd: `1s/A/x\ny/M`, cur: cursor{first: 1, second: 1, dot: 2, addrc: 1}, buf: append([]string{"x", "y"}, dummy.lines[1:]...)},
+		{cmd: `1,4s/\n/-/M2`, cur: cursor{first: 1, second: 4, dot: 2, addrc: 2}, buf: append([]string{"A", "B-C"}, dummy.lines[3:]...)},
+		{cmd: `,s/Y\nZ/end/Mp`, cur: cursor{first: 1, second: lc, dot: lc - 1, addrc: 2}, output: "end\n", buf: append(append([]string{}, dummy.lines[:lc-2]...), "end")},
+		{cmd: `,s/^$\n//M`, cur: cursor{first: 1, second: lc, dot: lc, addrc: 2}, err: ErrNoMatch, output: defaultErr},
+		{cmd: `,s/A/x/Mc`, cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "1s/A/x/gc\ny\nn\ny\nq", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, output: "A A A A A\n^\nx A A A A\n  ^\nx A A A A\n    ^\nx A x A A\n      ^\n", buf: append([]string{"x A x A A"}, subBuffer.lines[1:]...), sub: true},
+		{cmd: ",s/B/y/c\nz\na", cur: cursor{first: 1, second: slc, dot: 4, addrc: 2}, output: "B B B B B\n^\n?\n", buf: append(append([]string{}, subBuffer.lines[:2]...), append([]string{"y B B B B", "y B B B B"}, subBuffer.lines[4:]...)...), sub: true},
+		{cmd: "u", cur: cursor{first: 4, second: 4, dot: slc}, buf: subBuffer.lines, keep: true, sub: true},
+		{cmd: ",s/C/z/gc\nn\nn\nn\nn\nn\nq", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, output: "C C C C C\n^\nC C C C C\n  ^\nC C C C C\n    ^\nC C C C C\n      ^\nC C C C C\n        ^\nC C C C C\n^\n", sub: true, buf: subBuffer.lines},
 		{cmd: ",s/A/TEST/", cur: cursor{first: 1, second: slc, dot: 2, addrc: 2}, sub: true},
 		{cmd: ",s/A/%/", cur: cursor{first: 1, second: slc, dot: 2, addrc: 2}, buf: append([]string{"TEST TEST A A A", "TEST TEST A A A"}, subBuffer.lines[2:]...), keep: true, sub: true},
 
@@ -161,13 +201,16 @@ func TestEditor(t *testing.T) {
 		{cmd: "u\n", cur: cursor{first: 3, second: 3, dot: lc}, keep: true, buf: dummy.lines},
 		{cmd: "2,8d", cur: cursor{first: 2, second: 8, dot: 2, addrc: 2}},
 		{cmd: "u\n", cur: cursor{first: 2, second: 2, dot: lc}, keep: true, buf: dummy.lines},
+		{cmd: "5,6m1", cur: cursor{first: 5, second: 6, dot: 3, addrc: 1}, buf: append([]string{"A", "E", "F", "B", "C", "D"}, dummy.lines[6:]...)},
+		{cmd: "u\n", cur: cursor{first: 3, second: 3, dot: lc}, keep: true, buf: dummy.lines},
+		{cmd: "r /non-existing", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrCannotReadFile, output: defaultErr, buf: dummy.lines},
 
 		// w / wq / W - write
 		{cmd: ",d", cur: cursor{first: 1, second: lc, addrc: 2}},
 		{cmd: "w", output: "0\n", keep: true},
 		{cmd: "1w", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, output: "2\n"},
 		{cmd: "w", cur: cursor{first: 1, second: lc, dot: lc}, output: fmt.Sprintf("%d\n", lc*2)},
-		{cmd: fmt.Sprintf("w %s", tmp.Name()), cur: cursor{first: 1, second: lc, dot: lc}, output: fmt.Sprintf("%d\n", lc*2)},
+		{cmd: fmt.Sprintf("w %s", dummy.path), cur: cursor{first: 1, second: lc, dot: lc}, output: fmt.Sprintf("%d\n", lc*2)},
 
 		// z - scroll
 		{cmd: "2z6", cur: cursor{first: 1, second: 2, dot: 8, addrc: 1}, output: strings.Join(dummy.lines[1:8], "\n") + "\n"},
@@ -186,6 +229,7 @@ func TestEditor(t *testing.T) {
 
 		// a - append
 		{cmd: "az", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "100a\nx\n.", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidAddress, output: defaultErr, buf: dummy.lines},
 
 		// c - change
 		{cmd: "cz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
@@ -198,7 +242,7 @@ func TestEditor(t *testing.T) {
 		{cmd: fmt.Sprintf("1,%dd", lc+1), cur: cursor{first: 1, second: 1, dot: lc, addrc: 2}, err: ErrInvalidAddress, output: defaultErr},
 
 		// e - open file
-		{cmd: "1e", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1e", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "ez", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedCmdSuffix, output: defaultErr},
 		{cmd: "1d", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}},
 		{cmd: "e", cur: cursor{first: 1, second: 1, dot: 1}, err: ErrFileModified, keep: true, output: defaultErr},
@@ -206,30 +250,31 @@ func TestEditor(t *testing.T) {
 
 		// f - filename
 		{cmd: "fz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedCmdSuffix, output: defaultErr},
-		{cmd: "1f", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1f", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "f !", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidRedirection, output: defaultErr},
 		{cmd: "f", cur: cursor{first: lc, second: lc, dot: lc}, path: true, err: ErrNoFileName, output: defaultErr},
 
 		// v / V / g / G - global
 		{cmd: ",d", cur: cursor{first: 1, second: lc, dot: 0, addrc: 2}},
 		{cmd: "g/./p", cur: cursor{first: 1, second: 0, dot: 0}, keep: true, err: ErrInvalidAddress, output: defaultErr},
-		{cmd: "g/.*/g/.*/p", cur: cursor{first: 1, second: 1, dot: 1}, err: ErrCannotNestGlobal, output: defaultErr},
-		{cmd: "2,5g A p", cur: cursor{first: 2, second: 5, dot: lc, addrc: 2}, err: ErrInvalidPatternDelim, output: defaultErr},
-		{cmd: "g/A/\\", cur: cursor{first: 1, second: lc, dot: lc}, err: ErrUnexpectedEOF, output: defaultErr},
+		{cmd: "g/.*/g/.*/p", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrCannotNestGlobal, output: defaultErr},
+		{cmd: "2,5g A p", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidPatternDelim, output: defaultErr},
+		{cmd: "g/A/\\", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedEOF, output: defaultErr},
 		{cmd: "G/A.*/\n&", cur: cursor{first: 1, second: lc, dot: 1}, output: "A\n" + defaultErr, err: ErrNoPreviousCmd},
-		{cmd: "G/.*/\n,d", cur: cursor{first: 1, second: lc, dot: -24, addrc: 2}, output: "A\n" + defaultErr, err: ErrInvalidAddress},
+		{cmd: "G/.*/\n,d", cur: cursor{first: 1, second: lc, dot: 0, addrc: 2}, output: "A\n", buf: []string{}},
 		{cmd: "G/.*/\n\\", cur: cursor{first: 1, second: lc, dot: 1}, output: "A\n" + defaultErr, err: ErrUnexpectedEOF},
 		{cmd: "G/.*", cur: cursor{first: 1, second: lc, dot: 1}, output: "A\n" + defaultErr, err: ErrUnexpectedEOF},
 		{cmd: "G/.*\n\n", cur: cursor{first: 1, second: lc, dot: 2}, output: "A\nB\n" + defaultErr, err: ErrUnexpectedEOF},
+		{cmd: "g/A/s/A/x/\\\n@", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnknownCmd, output: defaultErr, buf: dummy.lines},
 		{cmd: "g/\n", cur: cursor{first: 1, second: lc, dot: lc}, err: ErrNoPrevPattern, output: defaultErr},
-		{cmd: "g/(abc/", cur: cursor{first: 1, second: lc, dot: lc}, err: &syntax.Error{Code: syntax.ErrorCode("missing closing )"), Expr: "(abc"}, output: defaultErr},
+		{cmd: "g/(abc/", cur: cursor{first: lc, second: lc, dot: lc}, err: &syntax.Error{Code: syntax.ErrorCode("missing closing )"), Expr: "(abc"}, output: defaultErr},
 		{cmd: "Gz", cur: cursor{first: 1, second: lc, dot: lc}, err: ErrNoPrevPattern, output: defaultErr},
 
 		// h / H - error message
-		{cmd: "1h", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
-		{cmd: "1H", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1h", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1H", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "Hz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
-		{cmd: "1x", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnknownCmd, output: defaultErr},
+		{cmd: "1x", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnknownCmd, output: defaultErr},
 		{cmd: "h", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnknownCmd, output: ErrUnknownCmd.Error() + "\n", keep: true},
 
 		// {cmd: "dz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
@@ -239,7 +284,7 @@ func TestEditor(t *testing.T) {
 		{cmd: "iz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// j - join
-		{cmd: "1,2jz", cur: cursor{first: 1, second: 2, dot: lc, addrc: 2}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "1,2jz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 		{cmd: "4,2j", cur: cursor{first: 4, second: 2, dot: lc, addrc: 2}, err: ErrInvalidAddress, output: defaultErr},
 
 		// k - mark
@@ -258,7 +303,7 @@ func TestEditor(t *testing.T) {
 		// m - move
 		{cmd: ",d", cur: cursor{first: 1, second: lc, addrc: 2}},
 		{cmd: "m5", keep: true, err: ErrInvalidAddress, output: defaultErr},
-		{cmd: "m1z", cur: cursor{first: lc, second: lc, dot: lc, addrc: 1}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "m1z", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 		{cmd: "1,5mz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrDestinationExpected, output: defaultErr},
 		{cmd: "m", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrDestinationExpected, output: defaultErr},
 		{cmd: "1,5m2", cur: cursor{first: 1, second: 5, dot: lc, addrc: 1}, err: ErrInvalidDestination, output: defaultErr},
@@ -266,14 +311,14 @@ func TestEditor(t *testing.T) {
 		//{cmd: "1m1a", cur: cursor{first: 1, second: 2, dot: 0, addrc: 1}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// P - prompt
-		{cmd: "1P", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1P", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "Pq", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// q / q - quit
 		{cmd: "1d", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}, err: nil},
 		{cmd: "q", cur: cursor{first: 1, second: 1, dot: 1}, keep: true, err: ErrFileModified, output: defaultErr},
 		{cmd: "qq", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
-		{cmd: "1Q", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1Q", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "Qq", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// r - read
@@ -289,16 +334,19 @@ func TestEditor(t *testing.T) {
 		// s - substitute
 		{cmd: "spz", cur: cursor{first: slc, second: slc, dot: slc}, err: ErrInvalidCmdSuffix, sub: true, output: defaultErr},
 		{cmd: ",s", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, err: ErrNoPrevPattern, sub: true, output: defaultErr},
-		{cmd: ",s/A/B/q", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, err: ErrInvalidCmdSuffix, sub: true, output: defaultErr},
+		{cmd: ",s/A/B/q", cur: cursor{first: slc, second: slc, dot: slc}, err: ErrInvalidCmdSuffix, sub: true, output: defaultErr},
 		{cmd: ",s/X/Y/", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, err: ErrNoMatch, sub: true, output: defaultErr},
 		{cmd: ",s//Y/", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, err: ErrNoPrevPattern, sub: true, output: defaultErr},
 		{cmd: "s/(abc/", cur: cursor{first: lc, second: lc, dot: lc, addrc: 0}, err: &syntax.Error{Code: syntax.ErrorCode("missing closing )"), Expr: "(abc"}, output: defaultErr},
 		{cmd: ",s/A/%/p", cur: cursor{first: 1, second: slc, dot: slc, addrc: 2}, sub: true, err: ErrNoPreviousSub, output: defaultErr},
+		{cmd: "s/A/B/0", cur: cursor{first: slc, second: slc, dot: slc}, sub: true, err: ErrNumberOutOfRange, output: defaultErr},
+		{cmd: `s/A/\1/`, cur: cursor{first: slc, second: slc, dot: slc}, sub: true, err: ErrNumberOutOfRange, output: defaultErr},
+		{cmd: "1s/A/B/6", cur: cursor{first: 1, second: 1, dot: slc, addrc: 1}, sub: true, err: ErrNoMatch, output: defaultErr},
 
 		// t - transfer
 		{cmd: fmt.Sprintf("%dt5", lc+2), cur: cursor{first: lc, second: lc, dot: lc, addrc: 1}, err: ErrInvalidAddress, output: defaultErr},
 		{cmd: "1,5tz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrDestinationExpected, output: defaultErr},
-		{cmd: "1,5t5z", cur: cursor{first: 1, second: 5, dot: lc, addrc: 1}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "1,5t5z", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 		{cmd: ",d", cur: cursor{first: 1, second: lc, addrc: 2}, buf: []string{}},
 		{cmd: "1t2", cur: cursor{addrc: 1}, keep: true, err: ErrInvalidAddress, output: defaultErr},
 		{cmd: ",d", cur: cursor{first: 1, second: lc, dot: 0, addrc: 2}, err: nil},
@@ -306,7 +354,7 @@ func TestEditor(t *testing.T) {
 
 		{cmd: "u", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrNothingToUndo, output: defaultErr},
 		{cmd: "uq", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
-		{cmd: "1u", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "1u", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 
 		// w - write
 		{cmd: "wz", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedCmdSuffix, output: defaultErr},
@@ -318,19 +366,19 @@ func TestEditor(t *testing.T) {
 		{cmd: "w /root/no-access", cur: cursor{first: 1, second: lc, dot: lc}, output: defaultErr, err: ErrCannotOpenFile},
 		{cmd: "1d", cur: cursor{first: 1, second: 1, dot: 1, addrc: 1}},
 		{cmd: "Wq", cur: cursor{first: 1, second: lc - 1, dot: 1}, sub: true, err: ErrFileModified, keep: true, output: "50\n" + defaultErr},
-		{cmd: fmt.Sprintf("WQ %s", tmp.Name()), cur: cursor{first: 1, second: lc, dot: lc}, output: fmt.Sprintf("%d\n", len(dummy.lines))},
+		{cmd: fmt.Sprintf("WQ %s", dummy.path), cur: cursor{first: 1, second: lc, dot: lc}, output: fmt.Sprintf("%d\n", len(dummy.lines))},
 
 		// z - scroll
-		{cmd: "1z1234567891234567891234567890", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}, err: ErrNumberOutOfRange, output: defaultErr},
+		{cmd: "1z1234567891234567891234567890", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrNumberOutOfRange, output: defaultErr},
 		{cmd: "z", cur: cursor{first: 1, second: lc + 1, dot: lc}, err: ErrInvalidAddress, output: defaultErr},
-		{cmd: "5zq", cur: cursor{first: 1, second: 5, dot: lc, addrc: 1}, err: ErrInvalidCmdSuffix, output: defaultErr},
+		{cmd: "5zq", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// = - line count
 		{cmd: "=q", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrInvalidCmdSuffix, output: defaultErr},
 
 		// ! - shell escape
 		{cmd: "!", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrNoCmd, output: defaultErr},
-		{cmd: "5!", cur: cursor{first: 5, second: 5, dot: lc, addrc: 1}, err: ErrUnexpectedAddress, output: defaultErr},
+		{cmd: "5!", cur: cursor{first: lc, second: lc, dot: lc}, err: ErrUnexpectedAddress, output: defaultErr},
 		{cmd: "!nonexistingcommnad", cur: cursor{first: lc, second: lc, dot: lc}, err: &exec.ExitError{}, output: defaultErr},
 		{cmd: "!echo %", cur: cursor{first: lc, second: lc, dot: lc}, path: true, err: ErrNoFileName, output: defaultErr},
 
@@ -354,14 +402,20 @@ func TestEditor(t *testing.T) {
 				ed = NewEditor(
 					WithStdout(&output),
 					WithStderr(&output),
-					withBuffer(buf),
+					WithFS(mem),
+					withBuffer(file{lines: slices.Clone(buf.lines), path: buf.path}),
 				)
 			}
 			WithStdin(strings.NewReader(test.cmd))(ed)
 			if test.path {
 				ed.file.path = ""
 			}
-			defer func() { recover() }() // allow tests that call os.Exit()
+			defer func() {
+				// allow tests that call os.Exit(), but not other panics
+				if r := recover(); r != nil && !strings.Contains(fmt.Sprint(r), "os.Exit") {
+					t.Fatalf("panic: %v", r)
+				}
+			}()
 			err := ed.run()
 			if err != test.err {
 				if xerr, ok := err.(*exec.ExitError); ok {
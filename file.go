@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"os"
 	"slices"
 	"strings"
@@ -52,21 +53,26 @@ func (f *file) move(start, end, dest int) int {
 	return dest + (end - start + 1)
 }
 
-func (f *file) write(path string, r rune, start, end int) (int, error) {
+// contents returns the lines start through end as they are written to
+// a file.
+func (f *file) contents(start, end int) string {
+	start = max(start-1, 0)
+	if end <= start {
+		return ""
+	}
+	return strings.Join(f.lines[start:end], "\n") + "\n"
+}
+
+func (f *file) write(fsys FS, path string, r rune, start, end int) (int, error) {
 	perms := os.O_CREATE | os.O_RDWR | os.O_TRUNC
 	if r == 'W' {
 		perms = perms&^os.O_TRUNC | os.O_APPEND
 	}
-	file, err := os.OpenFile(path, perms, 0666)
+	file, err := fsys.OpenFile(path, perms, 0666)
 	if err != nil {
 		return -1, ErrCannotOpenFile
 	}
-	start = max(start-1, 0)
-	lastline := ""
-	if len(f.lines) > 0 && f.lines[end-1] != "" {
-		lastline = "\n"
-	}
-	size, err := file.WriteString(strings.Join(f.lines[start:end], "\n") + lastline)
+	size, err := io.WriteString(file, f.contents(start, end))
 	if err != nil {
 		return -1, ErrCannotWriteFile
 	}
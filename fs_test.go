@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	if _, err := m.ReadFile("a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want %v, got %v", fs.ErrNotExist, err)
+	}
+	if _, err := m.OpenFile("a", os.O_WRONLY, 0666); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want %v, got %v", fs.ErrNotExist, err)
+	}
+	for _, step := range []struct {
+		flag int
+		data string
+		want string
+	}{
+		{flag: os.O_CREATE | os.O_WRONLY | os.O_TRUNC, data: "hello\n", want: "hello\n"},
+		{flag: os.O_CREATE | os.O_WRONLY | os.O_APPEND, data: "world\n", want: "hello\nworld\n"},
+		{flag: os.O_WRONLY | os.O_TRUNC, data: "x\n", want: "x\n"},
+	} {
+		f, err := m.OpenFile("a", step.flag, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(step.data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(nil); err != fs.ErrClosed {
+			t.Fatalf("write after close: want %v, got %v", fs.ErrClosed, err)
+		}
+		if b, err := m.ReadFile("a"); err != nil || string(b) != step.want {
+			t.Fatalf("want %q, got %q (%v)", step.want, b, err)
+		}
+	}
+	if _, err := m.OpenFile("a", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("want %v, got %v", fs.ErrExist, err)
+	}
+}
+
+func TestEditorFS(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("in", []byte("a\nb\n"))
+	m.WriteFile("more", []byte("c\n"))
+	var output strings.Builder
+	ed := NewEditor(WithStdout(&output), WithStderr(&output), WithFS(m), WithFile("in"))
+	WithStdin(strings.NewReader("$r more\nw out\n1W out\ne nonexisting\nr in\n"))(ed)
+	for err := error(nil); ed.input.pos >= 0; {
+		if err = ed.run(); err != nil {
+			ed.errorln(false, err)
+		}
+	}
+	if want := "4\n2\n6\n2\n?\n4\n"; output.String() != want {
+		t.Fatalf("want output %q, got %q", want, output.String())
+	}
+	if b, err := m.ReadFile("out"); err != nil || string(b) != "a\nb\nc\na\n" {
+		t.Fatalf("want %q, got %q (%v)", "a\nb\nc\na\n", b, err)
+	}
+	if _, err := os.Stat("out"); err == nil {
+		t.Fatal("file written to the operating system's file system")
+	}
+}
@@ -10,8 +10,11 @@ const EOF rune = -1
 
 type input struct {
 	*bufio.Scanner
-	buf string
-	pos int
+	le   *lineEditor // interactive line editor, nil unless stdin is a terminal
+	text bool        // reading text rather than commands
+	buf  string
+	pos  int
+	rec  func(ln string) // records every line read
 }
 
 func (i *input) match(s string) bool { return strings.ContainsAny(string(i.token()), s) }
@@ -36,13 +39,19 @@ func (i *input) token() rune {
 	return tok
 }
 
-func (i *input) insert(r rune) {
-	i.buf = string(r) + i.buf
-	i.pos = max(i.pos-1, 0)
-}
-
 func (i *input) Scan() bool {
+	if i.le != nil {
+		ln, ok := i.le.readLine(i.text)
+		i.doInput(ln)
+		if ok && i.rec != nil {
+			i.rec(ln)
+		}
+		return ok
+	}
 	eof := i.Scanner.Scan()
 	i.doInput(i.Scanner.Text())
+	if eof && i.rec != nil {
+		i.rec(i.buf)
+	}
 	return eof
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// lineEditor is a minimal interactive line editor that is used in place
+// of the plain scanner when the standard input is a terminal. It supports
+// erasing characters (^H, DEL), words (^W), the whole line (^U) and
+// filename completion with Tab.
+type lineEditor struct {
+	fd       int // terminal file descriptor, -1 to leave the terminal mode alone
+	r        *bufio.Reader
+	w        io.Writer
+	prompt   func() string
+	complete func(ln string) (string, []string)
+}
+
+// readLine reads a single line from the terminal. Completion is only
+// performed in command mode, in text mode a Tab is inserted as is.
+// ok is false on end-of-file.
+func (le *lineEditor) readLine(text bool) (ln string, ok bool) {
+	if le.fd >= 0 {
+		if old, err := makeRaw(le.fd); err == nil {
+			defer restoreTerm(le.fd, old)
+		}
+	}
+	var buf []rune
+	for {
+		r, _, err := le.r.ReadRune()
+		if err != nil {
+			return string(buf), len(buf) > 0
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprintln(le.w)
+			return string(buf), true
+		case 0x04: // ^D
+			if len(buf) == 0 {
+				return "", false
+			}
+		case 0x7f, '\b':
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+				le.erase(1)
+			}
+		case 0x15: // ^U
+			le.erase(len(buf))
+			buf = buf[:0]
+		case 0x17: // ^W
+			n := len(buf)
+			for n > 0 && unicode.IsSpace(buf[n-1]) {
+				n--
+			}
+			for n > 0 && !unicode.IsSpace(buf[n-1]) {
+				n--
+			}
+			le.erase(len(buf) - n)
+			buf = buf[:n]
+		case 0x1b: // ESC: skip the escape sequence (arrow keys etc.)
+			le.skipEscape()
+		case '\t':
+			if text || le.complete == nil {
+				buf = append(buf, r)
+				fmt.Fprint(le.w, string(r))
+				continue
+			}
+			s, candidates := le.complete(string(buf))
+			if len(candidates) > 0 {
+				fmt.Fprintf(le.w, "\n%s\n", strings.Join(candidates, "  "))
+				if le.prompt != nil {
+					fmt.Fprint(le.w, le.prompt())
+				}
+				fmt.Fprint(le.w, s)
+			} else if strings.HasPrefix(s, string(buf)) {
+				fmt.Fprint(le.w, s[len(string(buf)):])
+			} else {
+				le.erase(len(buf))
+				fmt.Fprint(le.w, s)
+			}
+			buf = []rune(s)
+		default:
+			if r != utf8.RuneError && unicode.IsPrint(r) {
+				buf = append(buf, r)
+				fmt.Fprint(le.w, string(r))
+			}
+		}
+	}
+}
+
+// readKey reads a single key press from the terminal.
+func (le *lineEditor) readKey() (rune, error) {
+	if le.fd >= 0 {
+		if old, err := makeRaw(le.fd); err == nil {
+			defer restoreTerm(le.fd, old)
+		}
+	}
+	r, _, err := le.r.ReadRune()
+	return r, err
+}
+
+func (le *lineEditor) erase(n int) {
+	fmt.Fprint(le.w, strings.Repeat("\b \b", n))
+}
+
+func (le *lineEditor) skipEscape() {
+	r, _, err := le.r.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	for {
+		r, _, err = le.r.ReadRune()
+		if err != nil || r == '~' || unicode.IsLetter(r) {
+			return
+		}
+	}
+}
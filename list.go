@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// listEscapes maps the characters that have a C-style escape sequence
+// in list mode.
+var listEscapes = map[rune]string{
+	'\\': `\\`,
+	'\a': `\a`,
+	'\b': `\b`,
+	'\f': `\f`,
+	'\r': `\r`,
+	'\t': `\t`,
+	'\v': `\v`,
+	'$':  `\$`,
+}
+
+// listLine renders ln the way it is displayed by the l command as
+// described by POSIX. Characters without an escape sequence in
+// listEscapes that are not printable are written as one three-digit
+// octal number per byte, and the end of the line is marked with a '$'.
+// Lines are folded to fit the terminal width with a trailing '\'. col is
+// the column the line starts at.
+func (ed *Editor) listLine(ln string, col int) string {
+	var (
+		sb    strings.Builder
+		spans = ed.matches(ln)
+		hl    bool
+		width = ed.cols
+	)
+	if width < 2 {
+		width = DefaultCols
+	}
+	write := func(s string, n int) {
+		if col+n > width-1 {
+			if hl {
+				sb.WriteString(highlightEnd)
+			}
+			sb.WriteString("\\\n")
+			if hl {
+				sb.WriteString(highlightStart)
+			}
+			col = 0
+		}
+		sb.WriteString(s)
+		col += n
+	}
+	for i := 0; ; {
+		if hl && i == spans[0][1] {
+			sb.WriteString(highlightEnd)
+			hl = false
+			spans = spans[1:]
+		}
+		if !hl && len(spans) > 0 && i == spans[0][0] {
+			sb.WriteString(highlightStart)
+			hl = true
+		}
+		if i >= len(ln) {
+			break
+		}
+		r, w := utf8.DecodeRuneInString(ln[i:])
+		s := ed.listRune(ln[i:i+w], r)
+		n := len(s)
+		if s == ln[i:i+w] {
+			n = 1
+		}
+		write(s, n)
+		i += w
+	}
+	write("$", 1)
+	return sb.String()
+}
+
+// listRune returns the list mode representation of r, whose encoding is s.
+func (ed *Editor) listRune(s string, r rune) string {
+	if esc, ok := listEscapes[r]; ok {
+		return esc
+	}
+	if (r == utf8.RuneError && len(s) == 1) || !unicode.IsPrint(r) || (r >= utf8.RuneSelf && (ed.strict || !ed.utf8)) {
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			fmt.Fprintf(&sb, "\\%03o", s[i])
+		}
+		return sb.String()
+	}
+	return s
+}
+
+// utf8Locale reports whether the character encoding of the current
+// locale is UTF-8.
+func utf8Locale() bool {
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := strings.ToLower(os.Getenv(env)); v != "" {
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		flags  suffix
+		cols   int
+		utf8   bool
+		strict bool
+		want   string
+	}{
+		{name: "plain", line: "hello", want: "hello$\n"},
+		{name: "escapes", line: "\\\a\b\f\r\t\v", want: `\\\a\b\f\r\t\v$` + "\n"},
+		{name: "dollar", line: "$HOME", want: `\$HOME$` + "\n"},
+		{name: "quote", line: `"'`, want: `"'$` + "\n"},
+		{name: "octal", line: "\x00\x1b\x7f", want: `\000\033\177$` + "\n"},
+		{name: "invalid utf8", line: "a\xffb", utf8: true, want: `a\377b$` + "\n"},
+		{name: "utf8 locale", line: "é", utf8: true, want: "é$\n"},
+		{name: "c locale", line: "é", want: `\303\251$` + "\n"},
+		{name: "strict", line: "é", utf8: true, strict: true, want: `\303\251$` + "\n"},
+		{name: "non-printable rune", line: "\u200b", utf8: true, want: `\342\200\213$` + "\n"},
+		{name: "fold", line: "abcdefghij", cols: 5, want: "abcd\\\nefgh\\\nij$\n"},
+		{name: "fold escape", line: "abc\tdef", cols: 5, want: "abc\\\n\\tde\\\nf$\n"},
+		{name: "fold dollar", line: "abcd", cols: 5, want: "abcd\\\n$\n"},
+		{name: "fold enumerate", line: "abcdefghij", cols: 12, flags: suffixEnumerate, want: "1\tabc\\\ndefghij$\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			ed := NewEditor(WithStdout(&output), withBuffer(file{lines: []string{test.line}}), WithStrictList(test.strict))
+			ed.utf8 = test.utf8
+			if test.cols > 0 {
+				ed.cols = test.cols
+			}
+			if err := ed.display(1, 1, suffixList|test.flags); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+		})
+	}
+}
+
+func TestListLongLine(t *testing.T) {
+	var output bytes.Buffer
+	ed := NewEditor(WithStdout(&output), withBuffer(file{lines: []string{strings.Repeat("x", 200)}}))
+	if err := ed.display(1, 1, suffixList); err != nil {
+		t.Fatal(err)
+	}
+	for _, ln := range strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n") {
+		if len(ln) > DefaultCols {
+			t.Fatalf("line %q is longer than %d columns", ln, DefaultCols)
+		}
+	}
+	if got := strings.NewReplacer("\\\n", "").Replace(output.String()); got != strings.Repeat("x", 200)+"$\n" {
+		t.Fatalf("unfolded output %q", got)
+	}
+}
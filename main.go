@@ -3,7 +3,12 @@
 //
 // Usage:
 //
-//	ed [-] [-s] [-p string] [file]
+//	ed [-] [-c] [-i] [-m] [-n] [-s] [-u] [-U] [-p string] [-t file] [file]
+//	ed -e file1 file2
+//	ed -l [script ...]
+//	ed -b script [-j n] file ...
+//	ed -J [file]
+//	ed -T transcript
 //
 // ed is a line-oriented text editor that operates on a file one line at a time.
 // It's designed for editing small to medium-sized files, and its simplicity makes
@@ -20,28 +25,136 @@
 //	,n          : Prints the entire buffer but with line numbers
 //	q           : Quit ed
 //
+// With -e, ed doesn't start an editing session but writes an ed script
+// to standard output that turns file1 into file2, like diff -e. The exit
+// status is 0 if the files are identical, 1 if they differ and 2 on error.
+//
+// With -l, ed parses the scripts, or standard input if none are given,
+// without running them and reports every syntax error with its line and
+// column. The exit status is 0 if there are none, 1 if there are and 2
+// if a script can't be read.
+//
+// With -n, ed runs a dry run: the commands are executed as usual, but
+// files are never written and shell commands are refused, including
+// those of r and e. When ed quits, the changes made to the buffer
+// are written to standard output as a unified diff.
+//
+// With -b, ed runs the script on each file, or on the files matched by
+// each glob pattern, with n editors in parallel. The script is parsed
+// once. For every file, the output of the script is written followed by
+// the number of bytes written or the error that stopped the script.
+// Quitting ends the script for that file. The exit status is 0 if the
+// script succeeded on every file, 1 if it failed on any and 2 if it
+// can't be parsed.
+//
+// With -J, ed is driven by line-delimited JSON-RPC 2.0 requests read
+// from standard input, for use by editor integrations. The responses
+// and change notifications are written to standard output. See serve
+// for the methods.
+//
+// With -u, the e and E commands keep the undo history, so that u
+// restores the buffer and the file name as they were before the other
+// file was edited.
+//
+// With -U, the undo history of a file is kept in the hidden file
+// .name.undo next to it whenever the whole buffer is written, and read
+// back when the file is edited again, unless its contents have changed.
+//
+// With -t, a transcript of the session is written to the named file: the
+// settings, the initial buffer and every line of input and output. With
+// -T, ed replays the session of a transcript, with its initial buffer in
+// memory, and reports the first point at which the output differs.
+// Shell commands aren't run during a replay: the first one is reported
+// as a difference. The exit status is 0 if there is none, 1 if there is
+// and 2 on error.
+//
 // For more information, refer to the OpenBSD man page: https://man.openbsd.org/ed.1
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
 var (
-	Prompt = flag.String("p", "", "user prompt")
-	Silent = flag.Bool("s", false, "suppress diagnostics")
+	Prompt    = flag.String("p", "", "user prompt")
+	Silent    = flag.Bool("s", false, "suppress diagnostics")
+	Highlight = flag.Bool("c", false, "highlight regular expression matches")
+	Pager     = flag.Bool("m", false, "page long output one screen at a time")
+	EdDiff    = flag.Bool("e", false, "write an ed script that turns file1 into file2")
+	SmartCase = flag.Bool("i", false, "patterns without upper case letters ignore case")
+	Lint      = flag.Bool("l", false, "report the syntax errors of scripts without running them")
+	DryRun    = flag.Bool("n", false, "print the changes as a diff instead of writing files")
+	Batch     = flag.String("b", "", "run the script on each file")
+	Jobs      = flag.Int("j", runtime.NumCPU(), "number of files edited in parallel by -b")
+	Server    = flag.Bool("J", false, "serve JSON-RPC requests on standard input")
+	Record    = flag.String("t", "", "record a transcript of the session to `file`")
+	Replay    = flag.Bool("T", false, "replay the session of a transcript")
+	KeepUndo  = flag.Bool("u", false, "keep the undo history when editing another file")
+	UndoFile  = flag.Bool("U", false, "keep the undo history of files next to them")
 )
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-] [-s] [-p string] [file]\n", filepath.Base(os.Args[0]))
+		name := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-] [-c] [-i] [-m] [-n] [-s] [-u] [-U] [-p string] [-t file] [file]\n", name)
+		fmt.Fprintf(os.Stderr, "       %s -e file1 file2\n", name)
+		fmt.Fprintf(os.Stderr, "       %s -l [script ...]\n", name)
+		fmt.Fprintf(os.Stderr, "       %s -b script [-j n] file ...\n", name)
+		fmt.Fprintf(os.Stderr, "       %s -J [file]\n", name)
+		fmt.Fprintf(os.Stderr, "       %s -T transcript\n", name)
 		os.Exit(1)
 	}
 	flag.Parse()
-	opts := []Option{WithStdin(os.Stdin), WithPrompt(*Prompt)}
+	if *EdDiff {
+		if flag.NArg() != 2 {
+			flag.Usage()
+		}
+		status, err := edDiff(os.Stdout, flag.Arg(0), flag.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+		}
+		os.Exit(status)
+	}
+	if *Lint {
+		os.Exit(lintFiles(os.Stdout, flag.Args()))
+	}
+	if *Batch != "" {
+		if flag.NArg() == 0 {
+			flag.Usage()
+		}
+		status, err := runBatch(os.Stdout, *Batch, flag.Args(), *Jobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+		}
+		os.Exit(status)
+	}
+	if *Server {
+		opts := []Option{WithSmartCase(*SmartCase), WithSilent(*Silent)}
+		if flag.NArg() > 0 {
+			opts = append(opts, WithFile(flag.Arg(0)))
+		}
+		if err := serve(os.Stdin, os.Stdout, opts...); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	if *Replay {
+		if flag.NArg() != 1 {
+			flag.Usage()
+		}
+		status, err := replayFile(os.Stdout, flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+		}
+		os.Exit(status)
+	}
+	opts := []Option{WithStdin(os.Stdin), WithPrompt(*Prompt), WithHighlight(*Highlight), WithPager(*Pager), WithSmartCase(*SmartCase), WithDryRun(*DryRun), WithKeepUndo(*KeepUndo), WithUndoFile(*UndoFile)}
 	if flag.NArg() > 0 {
 		arg := flag.Args()[0]
 		if arg == "-" {
@@ -50,6 +163,126 @@ func main() {
 			opts = append(opts, WithFile(arg))
 		}
 	}
-	opts = append(opts, WithSilent(*Silent))
+	opts = append([]Option{WithSilent(*Silent)}, opts...)
+	if *Record != "" {
+		f, err := os.Create(*Record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		opts = append(opts, WithRecord(f))
+	}
 	NewEditor(opts...).Run()
 }
+
+// edDiff writes an ed script to w that turns the file a into the file b.
+// It returns the exit status: 0 if the files are identical, 1 if they
+// differ and 2 if either of them can't be read.
+func edDiff(w io.Writer, a, b string) (int, error) {
+	alines, err := readFile(OSFS{}, a)
+	if err != nil {
+		return 2, fmt.Errorf("%s: %w", a, err)
+	}
+	blines, err := readFile(OSFS{}, b)
+	if err != nil {
+		return 2, fmt.Errorf("%s: %w", b, err)
+	}
+	chunks := diff(alines, blines)
+	if len(chunks) == 0 {
+		return 0, nil
+	}
+	writeEdScript(w, blines, chunks)
+	return 1, nil
+}
+
+// lintFiles reports the syntax errors of the scripts in paths, or of
+// standard input if paths is empty. It returns the exit status: 0 if
+// there are no errors, 1 if there are and 2 if a script can't be read.
+func lintFiles(w io.Writer, paths []string) int {
+	if len(paths) == 0 {
+		return lintStatus(lint(w, "<stdin>", os.Stdin))
+	}
+	status := 0
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+			status = 2
+			continue
+		}
+		status = max(status, lintStatus(lint(w, path, f)))
+		f.Close()
+	}
+	return status
+}
+
+// lintStatus returns the exit status for the result of lint and
+// reports err on standard error.
+func lintStatus(n int, err error) int {
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+		return 2
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// lint parses the script read from r without running it and writes
+// every syntax error to w as name:line:column: message. It returns the
+// number of errors and any error reading r. Parsing resumes on the line
+// after an error.
+func lint(w io.Writer, name string, r io.Reader) (int, error) {
+	_, errs, err := scanScript(r)
+	for _, err := range errs {
+		fmt.Fprintf(w, "%s:%s\n", name, err)
+	}
+	if err != nil {
+		return len(errs), fmt.Errorf("%s: %w", name, err)
+	}
+	return len(errs), nil
+}
+
+// runBatch runs the script at path on the files matched by args with
+// the given number of workers and writes the results to w. It returns
+// the exit status: 0 if the script succeeded on every file, 1 if it
+// failed on any and 2 if it can't be read or parsed.
+func runBatch(w io.Writer, path string, args []string, workers int) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 2, err
+	}
+	defer f.Close()
+	s, err := parseScript(f)
+	if err != nil {
+		return 2, fmt.Errorf("%s:%w", path, err)
+	}
+	paths, err := expandGlobs(args)
+	if err != nil {
+		return 2, err
+	}
+	if writeBatchResults(w, batch(s, paths, workers, WithSmartCase(*SmartCase))) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// replayFile replays the transcript at path and writes the first
+// difference to w. It returns the exit status: 0 if the output is the
+// same, 1 if it differs and 2 if the transcript can't be replayed.
+func replayFile(w io.Writer, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 2, err
+	}
+	diff, err := replay(string(data))
+	if err != nil {
+		return 2, fmt.Errorf("%s: %w", path, err)
+	} else if diff != "" {
+		fmt.Fprintln(w, diff)
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+// A ChangeOp is the kind of a Change.
+type ChangeOp int
+
+const (
+	ChangeInsert ChangeOp = iota // lines were inserted
+	ChangeDelete                 // lines were deleted
+)
+
+func (op ChangeOp) String() string {
+	if op == ChangeDelete {
+		return "delete"
+	}
+	return "insert"
+}
+
+// A Change describes a change made to the buffer. Inserted lines are
+// numbered First through Last as in the buffer after the change, and
+// deleted lines as in the buffer before it. Applying the changes in the
+// order they are observed turns a copy of the buffer into the current
+// buffer.
+type Change struct {
+	Op      ChangeOp
+	First   int
+	Last    int
+	Lines   []string // the inserted or deleted lines
+	Command string   // the command that made the change
+}
+
+// WithObserver registers fn to be called with every change made to the
+// buffer. fn must not modify the editor.
+func WithObserver(fn func(Change)) Option {
+	return func(ed *Editor) {
+		ed.observers = append(ed.observers, fn)
+	}
+}
+
+// inserted reports that lines were inserted before the line first.
+func (ed *Editor) inserted(first int, lines []string) {
+	ed.updateList(first, len(lines), false)
+	ed.notify(ChangeInsert, first, lines)
+}
+
+// deleted reports that lines were deleted from the line first onwards.
+func (ed *Editor) deleted(first int, lines []string) {
+	ed.updateList(first, len(lines), true)
+	ed.notify(ChangeDelete, first, lines)
+}
+
+func (ed *Editor) notify(op ChangeOp, first int, lines []string) {
+	if len(ed.observers) == 0 || len(lines) == 0 {
+		return
+	}
+	ch := Change{
+		Op:    op,
+		First: first,
+		Last:  first + len(lines) - 1,
+		Lines: append([]string{}, lines...),
+	}
+	if ed.running != nil {
+		ch.Command = ed.running.String()
+	}
+	for _, fn := range ed.observers {
+		fn(ch)
+	}
+}
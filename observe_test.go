@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestObserver(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "append", script: "2a\nx\ny\n.", want: []string{"insert 3-4 [x y] 2a\nx\ny\n."}},
+		{name: "insert", script: "1i\nx\n.", want: []string{"insert 1-1 [x] 1i\nx\n."}},
+		{name: "change", script: "2,3c\nx\n.", want: []string{"delete 2-3 [B C] 2,3c\nx\n.", "insert 2-2 [x] 2,3c\nx\n."}},
+		{name: "delete", script: "3,5d", want: []string{"delete 3-5 [C D E] 3,5d"}},
+		{name: "move", script: "1,2m5", want: []string{"delete 1-2 [A B] 1,2m5", "insert 4-5 [A B] 1,2m5"}},
+		{name: "move back", script: "5,6m1", want: []string{"delete 5-6 [E F] 5,6m1", "insert 2-3 [E F] 5,6m1"}},
+		{name: "transfer", script: "1,2t$", want: []string{"insert 27-28 [A B] 1,2t$"}},
+		{name: "join", script: "1,3j", want: []string{"delete 1-3 [A B C] 1,3j", "insert 1-1 [ABC] 1,3j"}},
+		{name: "substitute", script: "2s/B/x/", want: []string{"delete 2-2 [B] 2s/B/x/", "insert 2-2 [x] 2s/B/x/"}},
+		{name: "global", script: "g/[AC]/d", want: []string{"delete 1-1 [A] d", "delete 2-2 [C] d"}},
+		{name: "multi-line substitute", script: "1,3s/A\\nB/x/M", want: []string{"delete 1-2 [A B] 1,3s/A\\nB/x/M", "insert 1-1 [x] 1,3s/A\\nB/x/M"}},
+		{name: "undo", script: "1,2m5\nu", want: []string{"delete 1-2 [A B] 1,2m5", "insert 4-5 [A B] 1,2m5", "delete 4-5 [A B] u", "insert 1-2 [A B] u"}},
+		{name: "read", script: "2r !printf 'x\\ny\\n'", want: []string{"insert 3-4 [x y] 2r !printf 'x\\ny\\n'"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				replica = slices.Clone(dummy.lines)
+				got     []string
+			)
+			ed := NewEditor(WithStdout(&strings.Builder{}), WithStderr(&strings.Builder{}), withBuffer(file{lines: slices.Clone(dummy.lines)}), WithSilent(true), withEmbedded(), WithObserver(func(ch Change) {
+				switch ch.Op {
+				case ChangeInsert:
+					replica = slices.Insert(replica, ch.First-1, ch.Lines...)
+				case ChangeDelete:
+					if !slices.Equal(replica[ch.First-1:ch.Last], ch.Lines) {
+						t.Fatalf("%v deletes %q", ch, replica[ch.First-1:ch.Last])
+					}
+					replica = slices.Delete(replica, ch.First-1, ch.Last)
+				}
+				got = append(got, fmt.Sprintf("%s %d-%d %v %s", ch.Op, ch.First, ch.Last, ch.Lines, ch.Command))
+			}))
+			WithStdin(strings.NewReader(test.script))(ed)
+			for {
+				err := ed.run()
+				if ed.input.pos < 0 {
+					break
+				} else if err != nil && err != ErrFileModified && err != errQuit {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(replica, ed.file.lines) {
+				t.Fatalf("replica %q differs from buffer %q", replica, ed.file.lines)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
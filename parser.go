@@ -1,199 +1,595 @@
 package main
 
 import (
-	"errors"
-	"io"
 	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
-func (ed *Editor) parse() error {
-	var addr int
-	ed.addrc = 0
-	ed.first, ed.second = ed.dot, ed.dot
-	defer func() {
-		ed.addrc = min(ed.addrc, 2)
-		if ed.addrc == 1 || ed.addrc > 0 && ed.second != addr {
-			ed.first = ed.second
+// addrKind identifies the kind of an address term.
+type addrKind int
+
+const (
+	addrNumber addrKind = iota // line number
+	addrDot                    // .
+	addrLast                   // $
+	addrSearch                 // /re/ or ?re?
+	addrMark                   // 'x
+	addrOffset                 // +n, -n or ^n
+	addrRange                  // %, ',' or ';' starting an address list
+)
+
+// An addrTerm is a single term of an address, such as 5, /re/ or +2.
+type addrTerm struct {
+	kind    addrKind
+	op      rune     // '/', '?', '+', '-', '^', '%', ',' or ';'
+	n       int      // line number, offset or mark index
+	pattern string   // search pattern
+	fold    bool     // search ignores case
+	multi   bool     // search matches across lines
+	next    *address // address following a range
+}
+
+// An address is a sequence of terms evaluating to a single line, and the
+// separator that follows it, if any.
+type address struct {
+	terms []addrTerm
+	sep   rune
+}
+
+// A command is a parsed command line. Its addresses are only evaluated
+// when the command is executed.
+type command struct {
+	addrs  []address
+	name   rune     // command character, EOF for a null command
+	suffix suffix   // print suffix
+	text   []string // input text of a, c and i
+	arg    string   // file name or shell command
+	flag   rune     // q or Q of w and W, e of D
+	mark   rune     // mark of k
+	count  int      // scroll count of z, -1 if not given
+	dest   []address
+	sub    *subst
+	global *global
+	ext    string // name of a word or registered command, which takes arg as its arguments
+}
+
+// subst holds the arguments of the s command.
+type subst struct {
+	repeat  bool // s repeats the previous substitution
+	last    bool // r: use the previous regular expression
+	delim   rune
+	pattern string
+	replace string
+	open    bool // the replacement isn't terminated
+	nth     int  // occurrence to replace, -1 for all
+	global  bool // g of the repeating form
+	print   bool // p of the repeating form
+	fold    bool
+	confirm bool
+	multi   bool
+}
+
+// global holds the arguments of the g, G, v and V commands.
+type global struct {
+	delim   rune
+	pattern string
+	fold    bool
+	list    []*command // nil for G and V
+}
+
+// A parser parses command lines. more is used to read the following
+// lines of input text and continued command lists; text reports whether
+// the line is input text rather than a command. When parsing fails, line
+// and pos are the position at which it stopped.
+type parser struct {
+	input
+	more   func(text bool) (string, bool)
+	custom func(name string) bool // reports whether name is a registered command
+	check  func(c *command) error // checks the addresses of a, c and i before their text is read
+	line   int                    // lines read with more
+}
+
+// parse parses the command line ln. The lines following it are read
+// with more, which may be nil if there are none.
+func parse(ln string, more func(text bool) (string, bool)) (*command, error) {
+	if more == nil {
+		more = func(bool) (string, bool) { return "", false }
+	}
+	p := &parser{more: more}
+	p.doInput(ln)
+	return p.command()
+}
+
+// column returns the column at which the parser is in the current
+// line, counted in characters from 1.
+func (p *parser) column() int {
+	return utf8.RuneCountInString(p.buf[:min(p.pos, len(p.buf))]) + 1
+}
+
+// parseList parses a command list of a global command, one command per
+// line. The input text of a, c and i is read from the list itself, and
+// the terminating period of the last one may be omitted.
+func parseList(s string) ([]*command, error) {
+	var p parser
+	return p.list(s)
+}
+
+// list parses the command list s. If it fails, p.line and p.pos are set
+// to the position in s at which parsing stopped.
+func (p *parser) list(s string) ([]*command, error) {
+	var (
+		lines = strings.Split(s, "\n")
+		n     int
+		list  []*command
+	)
+	next := func(bool) (string, bool) {
+		if n == len(lines) {
+			return "", false
 		}
-	}()
-	for {
-		var err error
-		addr, err = ed.nextAddress()
+		n++
+		return lines[n-1], true
+	}
+	for ln, ok := next(false); ok; ln, ok = next(false) {
+		sub := &parser{more: next, custom: p.custom}
+		sub.doInput(ln)
+		p.line = n - 1
+		c, err := sub.command()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			p.line += sub.line
+			p.pos = sub.pos
+			return nil, err
+		} else if c.global != nil {
+			p.pos = 0
+			return nil, ErrCannotNestGlobal
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// nextLine reads the next line with more.
+func (p *parser) nextLine(text bool) (string, bool) {
+	ln, ok := p.more(text)
+	if ok {
+		p.line++
+	}
+	return ln, ok
+}
+
+func (p *parser) command() (*command, error) {
+	addrs, err := p.addrList()
+	if err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if c := p.registered(addrs); c != nil {
+		return c, nil
+	}
+	c := &command{addrs: addrs, name: p.token(), count: -1}
+	if c.name == '\n' {
+		c.name = EOF
+	}
+	switch c.name {
+	case 'e', 'E', 'f', 'h', 'H', 'P', 'q', 'Q', 'u', '!', 'A':
+		if len(addrs) > 0 {
+			return nil, ErrUnexpectedAddress
+		}
+	}
+	switch c.name {
+	case 'a', 'c', 'i':
+		p.consume()
+		if err := p.getSuffix(c); err != nil {
+			return nil, err
+		}
+		if p.check != nil {
+			if err := p.check(c); err != nil {
+				return nil, err
+			}
+		}
+		for {
+			ln, ok := p.nextLine(true)
+			if !ok || ln == "." {
 				break
 			}
-			return err
+			c.text = append(c.text, ln)
 		}
-		ed.addrc += 1
-		if addr < 1 {
-			ed.second = 0
-			break
+	case 'd', 'j', 'h', 'H', 'P', 'q', 'Q', 'u', '=':
+		p.consume()
+		return c, p.getSuffix(c)
+	case 'l', 'n', 'p':
+		return c, p.getSuffix(c)
+	case 'e', 'E', 'f', 'r', 'A':
+		p.consume()
+		if !unicode.IsSpace(p.token()) && !p.eof() {
+			return nil, ErrUnexpectedCmdSuffix
+		}
+		p.skipWhitespace()
+		c.arg = p.scanString()
+		if c.name == 'f' && strings.HasPrefix(c.arg, "!") {
+			return nil, ErrInvalidRedirection
+		} else if c.name == 'A' && c.arg == "" {
+			return nil, ErrInvalidFileName
 		}
-		ed.first = ed.second
-		ed.second = addr
-		if !ed.match(",;") {
+	case 'w', 'W':
+		p.consume()
+		if p.match("qQ") {
+			c.flag = p.token()
+			p.consume()
+		}
+		if !unicode.IsSpace(p.token()) && !p.eof() {
+			return nil, ErrUnexpectedCmdSuffix
+		}
+		p.skipWhitespace()
+		c.arg = p.scanString()
+	case 'D':
+		p.consume()
+		if p.token() == 'e' {
+			c.flag = 'e'
+			p.consume()
+		}
+		if !unicode.IsSpace(p.token()) && !p.eof() {
+			return nil, ErrUnexpectedCmdSuffix
+		}
+		p.skipWhitespace()
+		c.arg = p.scanString()
+	case '!':
+		p.consume()
+		if p.eof() {
+			return nil, ErrNoCmd
+		}
+		p.skipWhitespace()
+		c.arg = p.scanString()
+	case 'k':
+		p.consume()
+		c.mark = p.token()
+		p.consume()
+		if err := p.getSuffix(c); err != nil {
+			return nil, err
+		}
+		if !unicode.IsLower(c.mark) || int(c.mark-'a') >= len(file{}.mark) {
+			return nil, ErrInvalidMark
+		}
+	case 'm', 't':
+		p.consume()
+		if c.dest, err = p.addrList(); err != nil {
+			return nil, err
+		} else if len(c.dest) == 0 {
+			return nil, ErrDestinationExpected
+		}
+		return c, p.getSuffix(c)
+	case 'z':
+		p.consume()
+		if unicode.IsDigit(p.token()) {
+			if c.count, err = p.scanNumber(); err != nil {
+				return nil, err
+			}
+		}
+		return c, p.getSuffix(c)
+	case 's':
+		return c, p.substitute(c)
+	case 'g', 'G', 'v', 'V':
+		return c, p.global(c)
+	case EOF:
+	default:
+		return nil, ErrUnknownCmd
+	}
+	return c, nil
+}
+
+// addrList parses the addresses preceding a command.
+func (p *parser) addrList() ([]address, error) {
+	var addrs []address
+	for {
+		a, ok, err := p.address()
+		if err != nil {
+			return nil, err
+		} else if !ok {
 			break
 		}
-		r := ed.token()
-		ed.consume()
-		if r == ';' {
-			ed.dot = addr
+		if p.match(",;") {
+			a.sep = p.token()
+			p.consume()
+		}
+		addrs = append(addrs, a)
+		if a.sep == 0 {
+			break
 		}
 	}
-	return nil
+	return addrs, nil
 }
 
-func (ed *Editor) nextAddress() (int, error) {
-	var (
-		addr  = ed.dot
-		i     = ed.input.pos
-		err   error
-		first = true
-	)
-	ed.skipWhitespace()
-	for ; ; first = false {
+// address parses a single address. It reports false if there is none.
+func (p *parser) address() (address, bool, error) {
+	var a address
+	p.skipWhitespace()
+	for first := true; ; first = false {
+		t := addrTerm{op: p.token()}
 		switch {
-		case unicode.IsDigit(ed.token()), ed.match("+-^"):
-			r := ed.token()
-			if !unicode.IsDigit(r) {
-				ed.consume()
-			}
-			var n int
-			ed.skipWhitespace()
-			if unicode.IsDigit(ed.token()) {
-				n, err = ed.scanNumber()
+		case unicode.IsDigit(p.token()), p.match("+-^"):
+			t.kind, t.n = addrNumber, 1
+			if !unicode.IsDigit(t.op) {
+				t.kind = addrOffset
+				p.consume()
+			}
+			p.skipWhitespace()
+			if unicode.IsDigit(p.token()) {
+				n, err := p.scanNumber()
 				if err != nil {
-					return -1, err
+					return a, false, err
 				}
-			} else if !unicode.IsSpace(r) {
-				n = 1
-			}
-			switch r {
-			case '-', '^':
-				addr -= n
-			case '+':
-				addr += n
-			default:
-				addr = n
-			}
-		case ed.match(".$"):
+				t.n = n
+			}
+		case p.match(".$"):
 			if !first {
-				return -1, ErrInvalidAddress
+				return a, false, ErrInvalidAddress
 			}
-			addr = len(ed.lines)
-			if ed.token() == '.' {
-				addr = ed.dot
+			t.kind = addrDot
+			if t.op == '$' {
+				t.kind = addrLast
 			}
-			ed.consume()
-		case ed.match("?/"):
+			p.consume()
+		case p.match("?/"):
 			if !first {
-				return -1, ErrInvalidAddress
+				return a, false, ErrInvalidAddress
 			}
-			if len(ed.lines) < 1 {
-				return -1, ErrNoMatch
+			t.kind = addrSearch
+			p.consume()
+			var eof bool
+			t.pattern, eof = p.scanStringUntil(t.op)
+			if !eof && p.token() == t.op {
+				p.consume()
+				t.fold, t.multi = p.patternFlags()
 			}
-			r := ed.token()
-			ed.consume()
-			search, eof := ed.scanStringUntil(r)
-			if !eof && ed.token() == r {
-				ed.consume()
+			if err := checkPattern(t.pattern); err != nil {
+				return a, false, err
 			}
-			var re *regexp.Regexp
-			if search == "" {
-				if ed.re == nil {
-					return -1, ErrNoPrevPattern
-				}
-				re = ed.re
-			} else {
-				re, err = regexp.Compile(search)
-				if err != nil {
-					return -1, err
-				}
-				ed.re = re
-			}
-			i := ed.dot
-			if r != '/' {
-				i--
-			}
-			var found bool
-			for {
-				if r == '/' {
-					i++
-				} else {
-					i--
-					if i < 0 {
-						i = len(ed.lines) - 1
-					}
-				}
-				i %= len(ed.lines)
-				if re.MatchString(ed.lines[i]) {
-					addr = i + 1
-					found = true
-					break
-				} else if i+1 == ed.dot {
-					break
-				}
+		case p.token() == '\'':
+			if !first {
+				return a, false, ErrInvalidAddress
 			}
-			if !found {
-				return -1, ErrNoMatch
+			p.consume()
+			r := p.token()
+			if !unicode.IsLower(r) || int(r-'a') >= len(file{}.mark) {
+				return a, false, ErrInvalidMark
 			}
-		case ed.token() == '\'':
-			if !first {
-				return -1, ErrInvalidAddress
-			}
-			ed.consume()
-			r := ed.token()
-			m := int(r) - 'a'
-			if m < 0 || m >= len(ed.mark) || !unicode.IsLower(r) {
-				return -1, ErrInvalidMark
-			}
-			maddr := ed.mark[m]
-			if maddr < 1 || maddr > len(ed.lines) {
-				return -1, ErrInvalidAddress
-			}
-			addr = maddr
-			ed.consume()
-		case ed.match("%,;"):
-			if first {
-				ed.addrc += 1
-				ed.second = 1
-				if ed.token() == ';' {
-					ed.second = ed.dot
-				}
-				ed.consume()
-				if addr, err = ed.nextAddress(); err != nil {
-					addr = len(ed.lines)
-				}
+			t.kind, t.n = addrMark, int(r-'a')
+			p.consume()
+		case first && p.match("%,;"):
+			t.kind = addrRange
+			p.consume()
+			next, ok, err := p.address()
+			if err != nil {
+				return a, false, err
+			} else if ok {
+				t.next = &next
 			}
-			fallthrough
+			return address{terms: []addrTerm{t}}, true, nil
 		default:
-			if ed.input.pos == i {
-				return -1, io.EOF
+			return a, len(a.terms) > 0, nil
+		}
+		a.terms = append(a.terms, t)
+	}
+}
+
+// checkPattern reports a syntax error in the regular expression pattern.
+// An empty pattern stands for the previous one and is always valid.
+func checkPattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	_, err := regexp.Compile(pattern)
+	return err
+}
+
+// registered parses a command named by a word, either built-in or
+// registered: a word followed by whitespace or the end of the line, or
+// a single character. The rest of the line is its arguments.
+func (p *parser) registered(addrs []address) *command {
+	if p.eof() {
+		return nil
+	}
+	rest := p.buf[p.pos:]
+	after := strings.TrimLeftFunc(rest, unicode.IsLetter)
+	name := rest[:len(rest)-len(after)]
+	if r, _ := utf8.DecodeRuneInString(after); utf8.RuneCountInString(name) < 2 || after != "" && !unicode.IsSpace(r) || !p.named(name) {
+		_, n := utf8.DecodeRuneInString(rest)
+		if name = rest[:n]; !p.named(name) {
+			return nil
+		}
+	}
+	p.pos += len(name)
+	p.skipWhitespace()
+	return &command{addrs: addrs, ext: name, arg: p.scanString(), count: -1}
+}
+
+// named reports whether name is a built-in word or a registered command.
+func (p *parser) named(name string) bool {
+	if _, ok := words[name]; ok {
+		return true
+	}
+	return p.custom != nil && p.custom(name)
+}
+
+// getSuffix parses the print suffix that ends a command.
+func (p *parser) getSuffix(c *command) error {
+	for {
+		switch p.token() {
+		case 'n':
+			c.suffix |= suffixEnumerate
+		case 'l':
+			c.suffix |= suffixList
+		case 'p':
+			c.suffix |= suffixPrint
+		default:
+			if !p.eof() && p.token() != '\n' {
+				return ErrInvalidCmdSuffix
 			}
-			if addr < 0 || addr > len(ed.lines) {
-				ed.addrc += 1
-				return -1, ErrInvalidAddress
+			return nil
+		}
+		p.consume()
+	}
+}
+
+func (p *parser) substitute(c *command) error {
+	var err error
+	p.consume()
+	s := &subst{nth: 1}
+	c.sub = s
+	for p.match("gprI") || unicode.IsDigit(p.token()) {
+		s.repeat = true
+		switch r := p.token(); r {
+		case 'g':
+			s.global = true
+		case 'p':
+			s.print = true
+		case 'r':
+			s.last = true
+		case 'I':
+			s.fold = true
+		default:
+			if s.nth, err = p.scanNumber(); err != nil {
+				return err
+			} else if s.nth < 1 {
+				return ErrNumberOutOfRange
 			}
-			return addr, nil
+			continue
+		}
+		p.consume()
+	}
+	if s.repeat || p.eof() || p.token() == '\n' {
+		s.repeat = true
+		if s.global {
+			s.nth = -1
+		}
+		if !p.eof() && p.token() != '\n' {
+			return ErrInvalidCmdSuffix
+		}
+		return nil
+	}
+	s.delim = p.token()
+	p.consume()
+	var eof bool
+	s.pattern, eof = p.scanStringUntil(s.delim)
+	if !eof {
+		p.consume()
+	}
+	s.replace, eof = p.scanStringUntil(s.delim)
+	if !eof {
+		p.consume()
+	} else {
+		s.open = true
+	}
+flags:
+	for !eof {
+		switch r := p.token(); {
+		case r == 'g':
+			s.nth = -1
+		case r == 'I':
+			s.fold = true
+		case r == 'c':
+			s.confirm = true
+		case r == 'M':
+			s.multi = true
+		case unicode.IsDigit(r):
+			if s.nth, err = p.scanNumber(); err != nil {
+				return err
+			} else if s.nth < 1 {
+				return ErrNumberOutOfRange
+			}
+			continue
+		default:
+			if err := p.getSuffix(c); err != nil {
+				return err
+			}
+			break flags
+		}
+		p.consume()
+	}
+	if s.multi && s.confirm {
+		return ErrInvalidCmdSuffix
+	}
+	if err := checkPattern(s.pattern); err != nil {
+		return err
+	}
+	if s.pattern != "" && s.replace != "%" {
+		re := regexp.MustCompile(s.pattern)
+		if _, err := compileReplacement(s.replace, re.NumSubexp(), s.multi); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (p *parser) global(c *command) error {
+	p.consume()
+	g := &global{delim: p.token()}
+	c.global = g
+	if g.delim == ' ' || g.delim == '\n' || p.eof() {
+		return ErrInvalidPatternDelim
+	}
+	p.consume()
+	g.pattern, _ = p.scanStringUntil(g.delim)
+	if p.token() == g.delim {
+		p.consume()
+		if g.fold = p.token() == 'I'; g.fold {
+			p.consume()
+		}
+	}
+	if err := checkPattern(g.pattern); err != nil {
+		return err
+	}
+	if c.name == 'G' || c.name == 'V' {
+		return p.getSuffix(c)
+	}
+	line, col := p.line, p.pos
+	list, err := p.cmdList()
+	if err != nil {
+		return err
+	}
+	if list == "" {
+		list = "p"
+	}
+	sub := parser{custom: p.custom}
+	if g.list, err = sub.list(list); err != nil {
+		p.line, p.pos = line+sub.line, sub.pos
+		if sub.line == 0 {
+			p.pos += col
+		}
+	}
+	return err
 }
 
-func (ed *Editor) skipWhitespace() {
-	for ed.match(" \t") {
-		ed.consume()
+// cmdList reads the rest of the line as a command list. A line ending
+// with a backslash is continued on the next line.
+func (p *parser) cmdList() (string, error) {
+	var sb strings.Builder
+	ln := p.scanString()
+	for strings.HasSuffix(ln, "\\") {
+		sb.WriteString(strings.TrimSuffix(ln, "\\"))
+		sb.WriteByte('\n')
+		var ok bool
+		if ln, ok = p.nextLine(false); !ok {
+			return "", ErrUnexpectedEOF
+		}
 	}
+	sb.WriteString(ln)
+	return sb.String(), nil
 }
 
-func (ed *Editor) scanNumber() (int, error) {
+func (p *parser) skipWhitespace() {
+	for p.match(" \t") {
+		p.consume()
+	}
+}
+
+func (p *parser) scanNumber() (int, error) {
 	var sb strings.Builder
-	for unicode.IsDigit(ed.token()) {
-		sb.WriteRune(ed.token())
-		ed.consume()
+	for unicode.IsDigit(p.token()) {
+		sb.WriteRune(p.token())
+		p.consume()
 	}
 	n, err := strconv.Atoi(sb.String())
 	if err != nil {
@@ -202,16 +598,193 @@ func (ed *Editor) scanNumber() (int, error) {
 	return n, nil
 }
 
-func (ed *Editor) scanString() string {
-	s, _ := ed.scanStringUntil('\n')
+func (p *parser) scanString() string {
+	s, _ := p.scanStringUntil('\n')
 	return s
 }
 
-func (ed *Editor) scanStringUntil(delim rune) (str string, eof bool) {
+// patternFlags consumes the flags following the closing delimiter of a
+// search pattern: I ignores case and M matches across line boundaries.
+func (p *parser) patternFlags() (fold, multi bool) {
+	for {
+		switch p.token() {
+		case 'I':
+			fold = true
+		case 'M':
+			multi = true
+		default:
+			return fold, multi
+		}
+		p.consume()
+	}
+}
+
+func (p *parser) scanStringUntil(delim rune) (str string, eof bool) {
+	var sb strings.Builder
+	for !p.eof() && p.token() != delim {
+		sb.WriteRune(p.token())
+		p.consume()
+	}
+	return sb.String(), p.eof()
+}
+
+// String returns the command in canonical ed syntax.
+func (c *command) String() string {
 	var sb strings.Builder
-	for !ed.input.eof() && ed.token() != delim {
-		sb.WriteRune(ed.token())
-		ed.consume()
+	writeAddrs(&sb, c.addrs)
+	if c.name == EOF {
+		return sb.String()
+	}
+	if c.ext != "" {
+		sb.WriteString(c.ext)
+		if c.arg != "" {
+			sb.WriteString(" " + c.arg)
+		}
+		return sb.String()
+	}
+	sb.WriteRune(c.name)
+	sfx := c.suffix
+	switch c.name {
+	case 'l':
+		sfx &^= suffixList
+	case 'n':
+		sfx &^= suffixEnumerate
+	case 'p':
+		sfx &^= suffixPrint
+	case 'k':
+		sb.WriteRune(c.mark)
+	case 'm', 't':
+		writeAddrs(&sb, c.dest)
+	case 'z':
+		if c.count >= 0 {
+			sb.WriteString(strconv.Itoa(c.count))
+		}
+	case 'w', 'W', 'D':
+		if c.flag != 0 {
+			sb.WriteRune(c.flag)
+		}
+		fallthrough
+	case 'e', 'E', 'f', 'r', 'A':
+		if c.arg != "" {
+			sb.WriteString(" " + c.arg)
+		}
+	case '!':
+		sb.WriteString(c.arg)
+	case 's':
+		c.sub.writeTo(&sb)
+	case 'g', 'G', 'v', 'V':
+		g := c.global
+		sb.WriteString(string(g.delim) + g.pattern + string(g.delim))
+		if g.fold {
+			sb.WriteByte('I')
+		}
+		if g.list != nil {
+			list := make([]string, len(g.list))
+			for i, lc := range g.list {
+				list[i] = lc.String()
+			}
+			sb.WriteString(strings.ReplaceAll(strings.Join(list, "\n"), "\n", "\\\n"))
+		}
+	}
+	for _, s := range []struct {
+		flag suffix
+		r    byte
+	}{{suffixList, 'l'}, {suffixEnumerate, 'n'}, {suffixPrint, 'p'}} {
+		if sfx&s.flag > 0 {
+			sb.WriteByte(s.r)
+		}
+	}
+	if c.name == 'a' || c.name == 'c' || c.name == 'i' {
+		for _, ln := range c.text {
+			sb.WriteString("\n" + ln)
+		}
+		sb.WriteString("\n.")
+	}
+	return sb.String()
+}
+
+func (s *subst) writeTo(sb *strings.Builder) {
+	if s.repeat {
+		if s.last {
+			sb.WriteByte('r')
+		}
+		if s.global {
+			sb.WriteByte('g')
+		} else if s.nth > 1 {
+			sb.WriteString(strconv.Itoa(s.nth))
+		}
+		if s.fold {
+			sb.WriteByte('I')
+		}
+		if s.print {
+			sb.WriteByte('p')
+		}
+		return
+	}
+	d := string(s.delim)
+	sb.WriteString(d + s.pattern + d + s.replace + d)
+	if s.nth < 0 {
+		sb.WriteByte('g')
+	} else if s.nth > 1 {
+		sb.WriteString(strconv.Itoa(s.nth))
+	}
+	if s.fold {
+		sb.WriteByte('I')
+	}
+	if s.confirm {
+		sb.WriteByte('c')
+	}
+	if s.multi {
+		sb.WriteByte('M')
+	}
+	if s.open {
+		sb.WriteByte('p')
+	}
+}
+
+func writeAddrs(sb *strings.Builder, addrs []address) {
+	for _, a := range addrs {
+		writeAddr(sb, a)
+		if a.sep != 0 {
+			sb.WriteRune(a.sep)
+		}
+	}
+}
+
+func writeAddr(sb *strings.Builder, a address) {
+	for _, t := range a.terms {
+		switch t.kind {
+		case addrNumber:
+			sb.WriteString(strconv.Itoa(t.n))
+		case addrDot:
+			sb.WriteByte('.')
+		case addrLast:
+			sb.WriteByte('$')
+		case addrSearch:
+			sb.WriteString(string(t.op) + t.pattern + string(t.op))
+			if t.fold {
+				sb.WriteByte('I')
+			}
+			if t.multi {
+				sb.WriteByte('M')
+			}
+		case addrMark:
+			sb.WriteString("'" + string(rune('a'+t.n)))
+		case addrOffset:
+			op := t.op
+			if op == '^' {
+				op = '-'
+			}
+			sb.WriteString(string(op) + strconv.Itoa(t.n))
+		case addrRange:
+			op := t.op
+			if op == '%' {
+				op = ','
+			}
+			sb.WriteRune(op)
+			if t.next != nil {
+				writeAddr(sb, *t.next)
+			}
+		}
 	}
-	return sb.String(), ed.input.eof()
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"regexp/syntax"
@@ -24,6 +26,14 @@ func TestParser(t *testing.T) {
 		{cmd: "3,6", cur: cursor{first: 3, second: 6, dot: lc, addrc: 2}},
 		{cmd: "/C/,?G?", cur: cursor{first: 3, second: 7, dot: lc, addrc: 2}},
 		{cmd: "/", cur: cursor{first: 7, second: 7, dot: 7, addrc: 1}, keep: true},
+		{cmd: "/c/I", cur: cursor{first: 3, second: 3, dot: lc, addrc: 1}},
+		{cmd: "//", cur: cursor{first: 3, second: 3, dot: 3, addrc: 0}, keep: true, perr: ErrNoMatch},
+		{cmd: "?x?I,/z/I", cur: cursor{first: 24, second: lc, dot: lc, addrc: 2}},
+		{cmd: `/C\nD/M`, cur: cursor{first: 3, second: 3, dot: lc, addrc: 1}},
+		{cmd: `/^D$\n^E$/M`, cur: cursor{first: 4, second: 4, dot: lc, addrc: 1}},
+		{cmd: `?x\ny?MI`, cur: cursor{first: 24, second: 24, dot: lc, addrc: 1}},
+		{cmd: `/\nB/M`, cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}},
+		{cmd: `/Z\nA/M`, cur: cursor{first: lc, second: lc, dot: lc, addrc: 0}, perr: ErrNoMatch},
 		{cmd: "1,?Z?", cur: cursor{first: 1, second: lc, dot: lc, addrc: 2}},
 		{cmd: "1,", cur: cursor{first: 1, second: 1, dot: lc, addrc: 1}},
 		{cmd: "5", cur: cursor{first: 5, second: 5, dot: lc, addrc: 1}},
@@ -52,7 +62,10 @@ func TestParser(t *testing.T) {
 		{cmd: "9999999999999999999", cur: cursor{first: lc, second: lc, dot: lc, addrc: 0}, perr: ErrNumberOutOfRange},
 	}
 
-	var ed *Editor
+	var (
+		ed  *Editor
+		cmd *command
+	)
 	for _, test := range tests {
 		t.Run(fmt.Sprintf("%q", test.cmd), func(t *testing.T) {
 			if !test.keep {
@@ -63,13 +76,17 @@ func TestParser(t *testing.T) {
 					WithStderr(io.Discard),
 				)
 			} else {
-				_ = ed.exec() // Needed to validate the [cursor]
+				_ = ed.exec(cmd) // Needed to validate the [cursor]
 			}
 			if test.empty {
 				ed.file.lines = []string{}
 			}
-			ed.doInput(test.cmd)
-			if err := ed.parse(); err != test.perr {
+			ed.first, ed.second, ed.addrc = ed.dot, ed.dot, 0
+			var err error
+			if cmd, err = parse(test.cmd, nil); err == nil {
+				err = ed.resolve(cmd.addrs)
+			}
+			if err != test.perr {
 				if synerr, ok := err.(*syntax.Error); ok {
 					// TODO: verify the regexp.syntax.Error
 					_ = synerr
@@ -81,10 +98,128 @@ func TestParser(t *testing.T) {
 				t.Fatalf("want %+v, got %+v", test.cur, ed.cursor)
 			}
 			if test.xerr != nil {
-				if err := ed.exec(); err != test.xerr {
+				if err := ed.exec(cmd); err != test.xerr {
 					t.Fatalf("want exec error: %+v, got %+v", test.xerr, err)
 				}
 			}
 		})
 	}
 }
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		more []string
+		want string
+		err  error
+	}{
+		{cmd: "", want: ""},
+		{cmd: "%p", want: ",p"},
+		{cmd: "  ;  $d", want: ";$d"},
+		{cmd: "1,5s/a/b/gp", want: "1,5s/a/b/gp"},
+		{cmd: "/x/+ 2;$-d", want: "/x/+2;$-1d"},
+		{cmd: "?a?IM^3,'cn", want: "?a?IM-3,'cn"},
+		{cmd: "s/a/b", want: "s/a/b/p"},
+		{cmd: "s|a|b|3I", want: "s|a|b|3I"},
+		{cmd: "srg", want: "srg"},
+		{cmd: "w  file", want: "w file"},
+		{cmd: "wq", want: "wq"},
+		{cmd: "De", want: "De"},
+		{cmd: "3t0", want: "3t0"},
+		{cmd: "m$", want: "m$"},
+		{cmd: "pn", want: "pn"},
+		{cmd: "z5l", want: "z5l"},
+		{cmd: "kx", want: "kx"},
+		{cmd: "!ls -l", want: "!ls -l"},
+		{cmd: "a", more: []string{"hello", "world", "."}, want: "a\nhello\nworld\n."},
+		{cmd: "g/x/", want: "g/x/p"},
+		{cmd: `g/x/s/a/b/\`, more: []string{"i\\", "text"}, want: "g/x/s/a/b/\\\ni\\\ntext\\\n."},
+		{cmd: "V/x/Il", want: "V/x/Il"},
+
+		{cmd: "1,2x", err: ErrUnknownCmd},
+		{cmd: "1h", err: ErrUnexpectedAddress},
+		{cmd: "g/x/g/y/p", err: ErrCannotNestGlobal},
+		{cmd: `g/x/d\`, more: []string{"@"}, err: ErrUnknownCmd},
+		{cmd: `g/x/d\`, err: ErrUnexpectedEOF},
+		{cmd: "s/(/x/", err: &syntax.Error{Code: syntax.ErrMissingParen, Expr: "("}},
+		{cmd: `s/(a)/\2/`, err: ErrNumberOutOfRange},
+		{cmd: "m", err: ErrDestinationExpected},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			more := func(bool) (string, bool) {
+				if len(test.more) == 0 {
+					return "", false
+				}
+				ln := test.more[0]
+				test.more = test.more[1:]
+				return ln, true
+			}
+			c, err := parse(test.cmd, more)
+			if synerr, ok := test.err.(*syntax.Error); ok {
+				if err == nil || err.Error() != synerr.Error() {
+					t.Fatalf("want error %v, got %v", test.err, err)
+				}
+				return
+			} else if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := c.String(); got != test.want {
+				t.Fatalf("want %q, got %q", test.want, got)
+			}
+			lines := strings.Split(test.want, "\n")
+			test.more = lines[1:]
+			if c, err := parse(lines[0], more); err != nil || c.String() != test.want {
+				t.Fatalf("%q doesn't round trip", test.want)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "valid", script: "1,2p\na\nhello\n.\ns/a/b/g\nw\n", want: ""},
+		{name: "address", script: "1,2q\n", want: "s:1:4: unexpected address\n"},
+		{name: "suffix", script: "p\naz\n", want: "s:2:2: invalid command suffix\n"},
+		{name: "text", script: "a\nk!\n.\nk!\n", want: "s:4:3: invalid mark character\n"},
+		{name: "regexp", script: "s/a(/b/\n", want: "s:1:8: error parsing regexp: missing closing ): `a(`\n"},
+		{name: "multibyte", script: "s/é(/b/\n", want: "s:1:8: error parsing regexp: missing closing ): `é(`\n"},
+		{name: "global list", script: "g/x/p\\\n1,2q\n", want: "s:2:4: unexpected address\n"},
+		{name: "global first", script: "g/x/1,2q\n", want: "s:1:8: unexpected address\n"},
+		{name: "several", script: "zz\n1m\np\n", want: "s:1:2: invalid command suffix\ns:2:3: destination expected\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output strings.Builder
+			n, err := lint(&output, "s", strings.NewReader(test.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, output.String())
+			}
+			if want := strings.Count(test.want, "\n"); n != want {
+				t.Fatalf("want %d errors, got %d", want, n)
+			}
+		})
+	}
+}
+
+func TestLintReadError(t *testing.T) {
+	var output strings.Builder
+	script := "zz\n" + strings.Repeat("p", bufio.MaxScanTokenSize) + "\n"
+	n, err := lint(&output, "s", strings.NewReader(script))
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("want %v, got %v", bufio.ErrTooLong, err)
+	}
+	if want := "s:1:2: invalid command suffix\n"; n != 1 || output.String() != want {
+		t.Fatalf("want 1 error %q, got %d %q", want, n, output.String())
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxFuzz is the maximum number of context lines that may be ignored
+// at either end of a hunk when it doesn't apply cleanly.
+const maxFuzz = 2
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// A hunk is a single hunk of a unified diff. Every line keeps its ' ',
+// '-' or '+' prefix.
+type hunk struct {
+	header   string
+	oldStart int
+	oldLen   int
+	lines    []string
+}
+
+// parsePatch returns the hunks of the unified diff in lines. Anything
+// outside of the hunks, such as file headers, is ignored.
+func parsePatch(lines []string) ([]hunk, error) {
+	var hunks []hunk
+	for i := 0; i < len(lines); i++ {
+		m := hunkHeader.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		h := hunk{header: lines[i], oldLen: 1}
+		h.oldStart, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			h.oldLen, _ = strconv.Atoi(m[2])
+		}
+		newLen := 1
+		if m[4] != "" {
+			newLen, _ = strconv.Atoi(m[4])
+		}
+		for o, n := h.oldLen, newLen; o > 0 || n > 0; {
+			i++
+			if i >= len(lines) {
+				return nil, ErrMalformedPatch
+			}
+			ln := lines[i]
+			if ln == "" {
+				ln = " " // context lines with stripped trailing whitespace
+			}
+			switch ln[0] {
+			case ' ':
+				o--
+				n--
+			case '-':
+				o--
+			case '+':
+				n--
+			case '\\': // no newline at end of file
+				continue
+			default:
+				return nil, ErrMalformedPatch
+			}
+			if o < 0 || n < 0 {
+				return nil, ErrMalformedPatch
+			}
+			h.lines = append(h.lines, ln)
+		}
+		hunks = append(hunks, h)
+	}
+	if len(hunks) == 0 {
+		return nil, ErrMalformedPatch
+	}
+	return hunks, nil
+}
+
+// trim returns the old and new lines of the hunk with up to fuzz lines
+// of leading and trailing context removed, and the number of leading
+// lines removed.
+func (h hunk) trim(fuzz int) (old, repl []string, lead int) {
+	lines := h.lines
+	for lead < fuzz && len(lines) > 0 && lines[0][0] == ' ' {
+		lines = lines[1:]
+		lead++
+	}
+	for n := 0; n < fuzz && len(lines) > 0 && lines[len(lines)-1][0] == ' '; n++ {
+		lines = lines[:len(lines)-1]
+	}
+	for _, ln := range lines {
+		switch ln[0] {
+		case ' ':
+			old = append(old, ln[1:])
+			repl = append(repl, ln[1:])
+		case '-':
+			old = append(old, ln[1:])
+		case '+':
+			repl = append(repl, ln[1:])
+		}
+	}
+	return old, repl, lead
+}
+
+// locate searches the buffer for old, starting at the zero based index
+// pos and moving outwards, without going below the index low.
+func (ed *Editor) locate(old []string, pos, low int) (int, bool) {
+	lines := ed.file.lines
+	match := func(p int) bool {
+		if p < low || p+len(old) > len(lines) {
+			return false
+		}
+		for i, ln := range old {
+			if lines[p+i] != ln {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; pos-d >= low || pos+d <= len(lines); d++ {
+		if match(pos - d) {
+			return pos - d, true
+		}
+		if d > 0 && match(pos+d) {
+			return pos + d, true
+		}
+	}
+	return -1, false
+}
+
+// patch applies the hunks to the buffer. Hunks that can't be located,
+// even with an offset or fuzz, are rejected and reported. The applied
+// hunks are recorded as a single undo step.
+func (ed *Editor) patch(hunks []hunk) error {
+	var (
+		delta    int // lines added by the applied hunks
+		offset   int // offset of the previously applied hunk
+		low      int // end of the previously applied hunk
+		rejected int
+	)
+	for n, h := range hunks {
+		start := h.oldStart - 1 + delta
+		if h.oldLen == 0 {
+			start++
+		}
+		applied := false
+		for fuzz := 0; fuzz <= maxFuzz && !applied; fuzz++ {
+			old, repl, lead := h.trim(fuzz)
+			pos, ok := ed.locate(old, min(max(start+offset+lead, low), len(ed.file.lines)), low)
+			if !ok {
+				continue
+			}
+			ed.splice(pos+1, pos+len(old), repl)
+			offset = pos - lead - start
+			if !ed.silent && (fuzz > 0 || offset != 0) {
+				fmt.Fprintf(ed.stdout, "Hunk #%d succeeded at %d", n+1, pos-lead+1)
+				if fuzz > 0 {
+					fmt.Fprintf(ed.stdout, " with fuzz %d", fuzz)
+				}
+				if offset != 0 {
+					fmt.Fprintf(ed.stdout, " (offset %d lines)", offset)
+				}
+				fmt.Fprintln(ed.stdout, ".")
+			}
+			delta += len(repl) - len(old)
+			low = pos + len(repl)
+			ed.dot = max(low, 1)
+			applied = true
+		}
+		if !applied {
+			rejected++
+			fmt.Fprintf(ed.stdout, "Hunk #%d FAILED at %d.\n", n+1, max(start+1, 1))
+			fmt.Fprintf(ed.stdout, "%s\n%s\n", h.header, strings.Join(h.lines, "\n"))
+		}
+	}
+	if rejected < len(hunks) {
+		ed.dot = min(ed.dot, len(ed.file.lines))
+		ed.undo.store(ed.g)
+	}
+	if rejected > 0 {
+		fmt.Fprintf(ed.stdout, "%d out of %d hunks FAILED\n", rejected, len(hunks))
+		return ErrHunkFailed
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	a := strings.Split("a b c d e f g h i j k l m n o p", " ")
+	b := strings.Split("a B c d e f g h i j k l m o p q", " ")
+	var patch bytes.Buffer
+	writeUnified(&patch, a, b, diff(a, b), "old", "new", 3)
+	path := filepath.Join(t.TempDir(), "patch")
+	if err := os.WriteFile(path, patch.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		buf    []string
+		cmd    string
+		want   []string
+		output string
+		err    error
+	}{
+		{name: "clean", buf: a, cmd: "A " + path, want: b},
+		{name: "shell", buf: a, cmd: "A !cat " + path, want: b},
+		{
+			name:   "offset",
+			buf:    append([]string{"x", "y"}, a...),
+			cmd:    "A " + path,
+			want:   append([]string{"x", "y"}, b...),
+			output: "Hunk #1 succeeded at 3 (offset 2 lines).\nHunk #2 succeeded at 13 (offset 2 lines).\n",
+		},
+		{
+			name:   "fuzz",
+			buf:    append(slices.Clone(a[:4]), append([]string{"E"}, a[5:]...)...),
+			cmd:    "A " + path,
+			want:   append(slices.Clone(b[:4]), append([]string{"E"}, b[5:]...)...),
+			output: "Hunk #1 succeeded at 1 with fuzz 1.\n",
+		},
+		{
+			name:   "rejected",
+			buf:    append(slices.Clone(a[:10]), "K", "L", "M", "N", "O", "P"),
+			cmd:    "A " + path,
+			want:   append(slices.Clone(b[:10]), "K", "L", "M", "N", "O", "P"),
+			output: "Hunk #2 FAILED at 11.\n@@ -11,6 +11,6 @@\n k\n l\n m\n-n\n o\n p\n+q\n1 out of 2 hunks FAILED\n",
+			err:    ErrHunkFailed,
+		},
+		{name: "no file", buf: a, cmd: "A", want: a, err: ErrInvalidFileName},
+		{name: "address", buf: a, cmd: "1A " + path, want: a, err: ErrUnexpectedAddress},
+		{name: "malformed", buf: a, cmd: "A !echo nothing", want: a, err: ErrMalformedPatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			ed := NewEditor(
+				WithStdin(strings.NewReader(test.cmd+"\nu\n")),
+				WithStdout(&output),
+				withBuffer(file{lines: slices.Clone(test.buf)}),
+			)
+			if err := ed.run(); err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if output.String() != test.output {
+				t.Fatalf("want output %q, got %q", test.output, output.String())
+			}
+			if !slices.Equal(ed.file.lines, test.want) {
+				t.Fatalf("want buffer %q, got %q", test.want, ed.file.lines)
+			}
+			if test.err == ErrHunkFailed || test.err == nil {
+				if err := ed.run(); err != nil {
+					t.Fatalf("undo: %v", err)
+				}
+				if !slices.Equal(ed.file.lines, test.buf) {
+					t.Fatalf("want buffer %q after undo, got %q", test.buf, ed.file.lines)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		patch string
+		hunks int
+		err   error
+	}{
+		{patch: "--- a\n+++ b\n@@ -1 +1 @@\n-a\n+b\n", hunks: 1},
+		{patch: "@@ -1,2 +1,2 @@\n-a\n+b\n\n@@ -5,0 +6 @@\n+c\n", hunks: 2},
+		{patch: "@@ -1 +1 @@\n-a\n+b\n\\ No newline at end of file\n", hunks: 1},
+		{patch: "@@ -1,2 +1,2 @@\n-a\n+b\n", err: ErrMalformedPatch},
+		{patch: "@@ -1 +1 @@\n*a\n", err: ErrMalformedPatch},
+		{patch: "not a patch\n", err: ErrMalformedPatch},
+	}
+	for _, test := range tests {
+		hunks, err := parsePatch(strings.Split(strings.TrimSuffix(test.patch, "\n"), "\n"))
+		if err != test.err {
+			t.Fatalf("%q: want error %v, got %v", test.patch, test.err, err)
+		}
+		if len(hunks) != test.hunks {
+			t.Fatalf("%q: want %d hunks, got %d", test.patch, test.hunks, len(hunks))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A CommandFunc implements a command registered with Editor.Register.
+type CommandFunc func(cx *Context) error
+
+// A Context gives a registered command access to the editor while it
+// runs. Changes made through it are recorded as a single undo step.
+type Context struct {
+	ed      *Editor
+	cmd     *command
+	changed bool
+}
+
+// Register adds the command name to the editor. name is either a single
+// character or a word of two or more letters. A word is recognized when
+// it is followed by whitespace or the end of the line, and the rest of
+// the line is passed to the command as its arguments. It's an error to
+// register a name that is taken by a built-in command, or a word that
+// is a valid built-in command by itself, such as pn.
+func (ed *Editor) Register(name string, fn CommandFunc) error {
+	switch r, n := utf8.DecodeRuneInString(name); {
+	case n == len(name) && n > 0:
+		if _, ok := cmds[r]; ok {
+			return ErrCmdExists
+		} else if unicode.IsSpace(r) || unicode.IsDigit(r) || r == utf8.RuneError || strings.ContainsRune(".$,;/?'+-^", r) {
+			return ErrInvalidCmdName
+		}
+	case n > 0:
+		if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+			return ErrInvalidCmdName
+		} else if _, err := parse(name, nil); err == nil {
+			return ErrCmdExists
+		}
+	default:
+		return ErrInvalidCmdName
+	}
+	if _, ok := ed.commands[name]; ok {
+		return ErrCmdExists
+	}
+	if ed.commands == nil {
+		ed.commands = make(map[string]CommandFunc)
+	}
+	ed.commands[name] = fn
+	return nil
+}
+
+// registered reports whether name is a registered command.
+func (ed *Editor) registered(name string) bool {
+	_, ok := ed.commands[name]
+	return ok
+}
+
+// runRegistered runs the registered command of c and records the
+// changes it made for undo.
+func (ed *Editor) runRegistered(c *command) error {
+	fn, ok := ed.commands[c.ext]
+	if !ok {
+		return ErrUnknownCmd
+	}
+	cx := &Context{ed: ed, cmd: c}
+	err := fn(cx)
+	if cx.changed {
+		ed.undo.store(ed.g)
+	}
+	return err
+}
+
+// Name returns the name of the command.
+func (cx *Context) Name() string { return cx.cmd.ext }
+
+// Args returns the text following the command name, without leading
+// whitespace.
+func (cx *Context) Args() string { return cx.cmd.arg }
+
+// Addrs returns the addressed range and the number of addresses given.
+// Without addresses, first and second are both dot.
+func (cx *Context) Addrs() (first, second, n int) {
+	return cx.ed.first, cx.ed.second, cx.ed.addrc
+}
+
+// Range returns the addressed range, or first through second if no
+// address was given. It fails if the range is outside of the buffer.
+func (cx *Context) Range(first, second int) (int, int, error) {
+	if err := cx.ed.validate(first, second); err != nil {
+		return 0, 0, err
+	}
+	return cx.ed.first, cx.ed.second, nil
+}
+
+// GetSuffix parses the arguments as a print suffix, any of l, n and p.
+// The current line is printed accordingly once the command returns.
+func (cx *Context) GetSuffix() error {
+	p := &parser{}
+	p.doInput(cx.cmd.arg)
+	var c command
+	if err := p.getSuffix(&c); err != nil {
+		return err
+	}
+	cx.ed.cs |= c.suffix
+	return nil
+}
+
+// Len returns the number of lines in the buffer.
+func (cx *Context) Len() int { return len(cx.ed.file.lines) }
+
+// Lines returns a copy of the lines first through last, or none if last
+// is first-1. It fails if the lines are outside of the buffer.
+func (cx *Context) Lines(first, last int) ([]string, error) {
+	if first < 1 || last < first-1 || last > len(cx.ed.file.lines) {
+		return nil, ErrInvalidAddress
+	}
+	return slices.Clone(cx.ed.file.lines[first-1 : last]), nil
+}
+
+// Dot returns the current line.
+func (cx *Context) Dot() int { return cx.ed.dot }
+
+// SetDot sets the current line.
+func (cx *Context) SetDot(n int) error {
+	if n < 0 || n > len(cx.ed.file.lines) {
+		return ErrInvalidAddress
+	}
+	cx.ed.dot = n
+	return nil
+}
+
+// Replace replaces the lines first through last with lines, or inserts
+// them before the line first if last is first-1. Dot is set to the last
+// inserted line, or the line before them if there are none.
+func (cx *Context) Replace(first, last int, lines []string) error {
+	if first < 1 || last < first-1 || last > len(cx.ed.file.lines) {
+		return ErrInvalidAddress
+	}
+	cx.ed.splice(first, last, lines)
+	cx.ed.dot = first + len(lines) - 1
+	cx.changed = true
+	return nil
+}
+
+// Output returns the writer that the command should print to.
+func (cx *Context) Output() io.Writer { return cx.ed.stdout }
+
+// Silent reports whether diagnostics, such as byte counts, should be
+// suppressed.
+func (cx *Context) Silent() bool { return cx.ed.silent }
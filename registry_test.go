@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "rev"},
+		{name: "#"},
+		{name: "p", err: ErrCmdExists},
+		{name: "pn", err: ErrCmdExists},
+		{name: "sort", err: ErrCmdExists},
+		{name: "earlier", err: ErrCmdExists},
+		{name: "rev", err: ErrCmdExists},
+		{name: "", err: ErrInvalidCmdName},
+		{name: "5", err: ErrInvalidCmdName},
+		{name: "/", err: ErrInvalidCmdName},
+		{name: "x1", err: ErrInvalidCmdName},
+	}
+	ed := NewEditor()
+	for _, test := range tests {
+		if err := ed.Register(test.name, func(*Context) error { return nil }); err != test.err {
+			t.Fatalf("%q: want error %v, got %v", test.name, test.err, err)
+		}
+	}
+	if other := NewEditor(); other.registered("rev") {
+		t.Fatal("registered commands are shared between editors")
+	}
+}
+
+func TestContextLines(t *testing.T) {
+	cx := &Context{ed: NewEditor(withBuffer(file{lines: []string{"a", "b", "c"}}))}
+	tests := []struct {
+		first, last int
+		want        []string
+		err         error
+	}{
+		{first: 1, last: 3, want: []string{"a", "b", "c"}},
+		{first: 2, last: 2, want: []string{"b"}},
+		{first: 4, last: 3, want: []string{}},
+		{first: 0, last: 1, err: ErrInvalidAddress},
+		{first: 2, last: 4, err: ErrInvalidAddress},
+		{first: 3, last: 1, err: ErrInvalidAddress},
+	}
+	for _, test := range tests {
+		lines, err := cx.Lines(test.first, test.last)
+		if err != test.err {
+			t.Fatalf("%d,%d: want error %v, got %v", test.first, test.last, test.err, err)
+		}
+		if !slices.Equal(lines, test.want) {
+			t.Fatalf("%d,%d: want %q, got %q", test.first, test.last, test.want, lines)
+		}
+	}
+}
+
+func TestRegisteredCommand(t *testing.T) {
+	rev := func(cx *Context) error {
+		first, second, err := cx.Range(1, cx.Len())
+		if err != nil {
+			return err
+		}
+		lines, err := cx.Lines(first, second)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(lines)
+		if err := cx.Replace(first, second, lines); err != nil {
+			return err
+		}
+		return cx.GetSuffix()
+	}
+	echo := func(cx *Context) error {
+		first, second, n := cx.Addrs()
+		fmt.Fprintf(cx.Output(), "%s %d,%d %d %q\n", cx.Name(), first, second, n, cx.Args())
+		return nil
+	}
+	tests := []struct {
+		cmd    string
+		output string
+		buf    []string
+		err    error
+	}{
+		{cmd: "1,3rev", buf: append([]string{"C", "B", "A"}, dummy.lines[3:]...)},
+		{cmd: "1,3rev p", output: "A\n", buf: append([]string{"C", "B", "A"}, dummy.lines[3:]...)},
+		{cmd: "1,3rev x", err: ErrInvalidCmdSuffix, buf: append([]string{"C", "B", "A"}, dummy.lines[3:]...)},
+		{cmd: "1,3revx", err: ErrUnexpectedCmdSuffix, buf: dummy.lines},
+		{cmd: "2;+2# a  comment", output: "# 2,4 2 \"a  comment\"\n", buf: dummy.lines},
+		{cmd: "g/[AB]/.,+1rev", buf: append([]string{"B", "A"}, dummy.lines[2:]...)},
+		{cmd: "1,2rev\nu", buf: dummy.lines},
+		{cmd: "30rev", err: ErrInvalidAddress, buf: dummy.lines},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			var output strings.Builder
+			ed := NewEditor(WithStdout(&output), WithStderr(&output), withBuffer(file{lines: slices.Clone(dummy.lines)}))
+			if err := ed.Register("rev", rev); err != nil {
+				t.Fatal(err)
+			}
+			if err := ed.Register("#", echo); err != nil {
+				t.Fatal(err)
+			}
+			WithStdin(strings.NewReader(test.cmd))(ed)
+			var err error
+			for err == nil && ed.input.pos >= 0 {
+				err = ed.run()
+			}
+			if err == ErrFileModified {
+				err = nil // end of input
+			}
+			if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if output.String() != test.output {
+				t.Fatalf("want output %q, got %q", test.output, output.String())
+			}
+			if !slices.Equal(ed.file.lines, test.buf) {
+				t.Fatalf("want buffer %q, got %q", test.buf, ed.file.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type replKind int
+
+const (
+	replLiteral replKind = iota // literal text
+	replGroup                   // text matched by a subexpression, 0 is the whole match
+	replCase                    // case conversion escape
+)
+
+type replPart struct {
+	kind  replKind
+	text  string
+	group int
+	conv  rune
+}
+
+// A replacement is a compiled replacement string of the s command. It
+// is compiled once and expanded for every match.
+type replacement []replPart
+
+// compileReplacement compiles the replacement string s for a regular
+// expression with ngroups subexpressions. '&' is replaced with the whole
+// match, \1 through \9 with the text matched by the subexpression and
+// \U, \L, \u, \l and \E are case conversion escapes. If newline is set,
+// \n is replaced with a newline.
+func compileReplacement(s string, ngroups int, newline bool) (replacement, error) {
+	var (
+		repl replacement
+		lit  strings.Builder
+	)
+	flush := func() {
+		if lit.Len() > 0 {
+			repl = append(repl, replPart{kind: replLiteral, text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		i += w
+		switch {
+		case r == '&':
+			flush()
+			repl = append(repl, replPart{kind: replGroup})
+		case r == '\\' && i < len(s):
+			next, nw := utf8.DecodeRuneInString(s[i:])
+			switch {
+			case next == '&':
+				i += nw
+				lit.WriteRune(next)
+			case unicode.IsDigit(next):
+				i += nw
+				d := int(next - '0')
+				if d < 1 || d > ngroups {
+					return nil, ErrNumberOutOfRange
+				}
+				flush()
+				repl = append(repl, replPart{kind: replGroup, group: d})
+			case next == 'n' && newline:
+				i += nw
+				lit.WriteByte('\n')
+			case strings.ContainsRune("ULulE", next):
+				i += nw
+				flush()
+				repl = append(repl, replPart{kind: replCase, conv: next})
+			default:
+				lit.WriteRune(r)
+			}
+		default:
+			lit.WriteRune(r)
+		}
+	}
+	flush()
+	return repl, nil
+}
+
+// expand appends the replacement for the match m in src to sb. m holds
+// the index pairs of the match and its subexpressions, as returned by
+// FindAllStringSubmatchIndex.
+func (repl replacement) expand(sb *strings.Builder, src string, m []int) {
+	var conv caseConv
+	for _, p := range repl {
+		switch p.kind {
+		case replLiteral:
+			conv.writeString(sb, p.text)
+		case replGroup:
+			if start, end := m[2*p.group], m[2*p.group+1]; start >= 0 {
+				conv.writeString(sb, src[start:end])
+			}
+		case replCase:
+			conv.set(p.conv)
+		}
+	}
+}
+
+// caseConv implements the case conversion escapes of the replacement
+// string: \U and \L convert the rest of the replacement, or everything up
+// to \E, to upper or lower case, \u and \l convert the next character.
+type caseConv struct {
+	mode rune // 'U', 'L' or 0
+	next rune // 'u', 'l' or 0
+}
+
+func (c *caseConv) set(r rune) {
+	switch r {
+	case 'U', 'L':
+		c.mode = r
+	case 'E':
+		c.mode, c.next = 0, 0
+	default:
+		c.next = r
+	}
+}
+
+func (c *caseConv) writeString(sb *strings.Builder, s string) {
+	if c.mode == 0 && c.next == 0 {
+		sb.WriteString(s)
+		return
+	}
+	for _, r := range s {
+		switch {
+		case c.next == 'u':
+			r = unicode.ToTitle(r)
+		case c.next == 'l':
+			r = unicode.ToLower(r)
+		case c.mode == 'U':
+			r = unicode.ToUpper(r)
+		case c.mode == 'L':
+			r = unicode.ToLower(r)
+		}
+		c.next = 0
+		sb.WriteRune(r)
+	}
+}
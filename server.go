@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// JSON-RPC 2.0 error codes. Errors returned by commands use
+// rpcCommandFailed.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcCommandFailed  = 1
+)
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	Version string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// runParams are the parameters of the run method. Input holds the lines
+// read by the command, such as the text of a, c and i.
+type runParams struct {
+	Command string   `json:"command"`
+	Input   []string `json:"input"`
+}
+
+type runResult struct {
+	Output string `json:"output"`
+}
+
+// rangeParams are the parameters of the read method. A zero line
+// defaults to the first or last line of the buffer.
+type rangeParams struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+type rangeResult struct {
+	Lines []string `json:"lines"`
+}
+
+type stateResult struct {
+	Path  string         `json:"path"`
+	Dot   int            `json:"dot"`
+	Lines int            `json:"lines"`
+	Dirty bool           `json:"dirty"`
+	Marks map[string]int `json:"marks"`
+}
+
+type subscribeResult struct {
+	Subscribed bool `json:"subscribed"`
+}
+
+// A changeEvent is the notification sent for a Change.
+type changeEvent struct {
+	Command string   `json:"command"`
+	Op      string   `json:"op"`
+	First   int      `json:"first"`
+	Last    int      `json:"last"`
+	Lines   []string `json:"lines"`
+}
+
+// A server drives an editor with line-delimited JSON-RPC 2.0 requests.
+type server struct {
+	ed         *Editor
+	enc        *json.Encoder
+	output     bytes.Buffer
+	subscribed bool
+	changes    []changeEvent
+	quit       bool
+}
+
+// serve reads requests from r and writes the responses and change
+// notifications to w until r is exhausted or the editor quits. The
+// methods are:
+//
+//	run          execute a command and return its output
+//	read         return the lines first through last
+//	state        return the path, dot, line count, dirty flag and marks
+//	subscribe    send a changed notification for every change
+//	unsubscribe  stop sending changed notifications
+func serve(r io.Reader, w io.Writer, opts ...Option) error {
+	s := &server{enc: json.NewEncoder(w)}
+	opts = append([]Option{withEmbedded(), WithStdin(strings.NewReader("")), WithStdout(&s.output), WithStderr(&s.output), WithObserver(s.observe)}, opts...)
+	s.ed = NewEditor(opts...)
+	br := bufio.NewReader(r)
+	for !s.quit {
+		ln, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(ln)) > 0 {
+			if err := s.handle(ln); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle handles a single request.
+func (s *server) handle(ln []byte) error {
+	var req rpcRequest
+	if err := json.Unmarshal(ln, &req); err != nil {
+		return s.reply(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+	} else if req.Version != "2.0" || req.Method == "" {
+		return s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+	}
+	var (
+		result any
+		rpcErr *rpcError
+	)
+	switch req.Method {
+	case "run":
+		var p runParams
+		if rpcErr = unmarshalParams(req.Params, &p); rpcErr == nil {
+			result, rpcErr = s.run(p)
+		}
+	case "read":
+		var p rangeParams
+		if rpcErr = unmarshalParams(req.Params, &p); rpcErr == nil {
+			result, rpcErr = s.read(p)
+		}
+	case "state":
+		result = s.state()
+	case "subscribe", "unsubscribe":
+		s.subscribed = req.Method == "subscribe"
+		result = subscribeResult{Subscribed: s.subscribed}
+	default:
+		rpcErr = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	if req.ID != nil {
+		if err := s.reply(req.ID, result, rpcErr); err != nil {
+			return err
+		}
+	}
+	changes := s.changes
+	s.changes = nil
+	for _, ev := range changes {
+		if err := s.enc.Encode(rpcNotification{Version: "2.0", Method: "changed", Params: ev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observe queues a notification for ch if the client is subscribed.
+func (s *server) observe(ch Change) {
+	if s.subscribed {
+		s.changes = append(s.changes, changeEvent{
+			Command: ch.Command,
+			Op:      ch.Op.String(),
+			First:   ch.First,
+			Last:    ch.Last,
+			Lines:   ch.Lines,
+		})
+	}
+}
+
+func (s *server) reply(id json.RawMessage, result any, err *rpcError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if err != nil {
+		result = nil
+	}
+	return s.enc.Encode(rpcResponse{Version: "2.0", ID: id, Result: result, Error: err})
+}
+
+func unmarshalParams(params json.RawMessage, v any) *rpcError {
+	if params == nil {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// run executes a command the way it would have been entered on the
+// command line, with the input lines as the following lines of input.
+func (s *server) run(p runParams) (any, *rpcError) {
+	ed := s.ed
+	s.output.Reset()
+	WithStdin(strings.NewReader(strings.Join(p.Input, "\n")))(ed)
+	ed.current = nil
+	ed.first, ed.second, ed.addrc = ed.dot, ed.dot, 0
+	c, err := ed.parse(p.Command)
+	if err == nil {
+		err = ed.do(c)
+	}
+	if errors.Is(err, errQuit) {
+		s.quit, err = true, nil
+	}
+	result := runResult{Output: s.output.String()}
+	ed.err = err
+	if err != nil {
+		return nil, &rpcError{Code: rpcCommandFailed, Message: err.Error(), Data: result}
+	}
+	return result, nil
+}
+
+// read returns the lines p.First through p.Last.
+func (s *server) read(p rangeParams) (any, *rpcError) {
+	lines := s.ed.file.lines
+	if p.First == 0 {
+		p.First = min(1, len(lines))
+	}
+	if p.Last == 0 {
+		p.Last = len(lines)
+	}
+	if p.First > p.Last || p.First < 0 || p.Last > len(lines) {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: ErrInvalidAddress.Error()}
+	}
+	return rangeResult{Lines: append([]string{}, lines[max(p.First-1, 0):p.Last]...)}, nil
+}
+
+func (s *server) state() stateResult {
+	ed := s.ed
+	st := stateResult{
+		Path:  ed.path,
+		Dot:   ed.dot,
+		Lines: len(ed.file.lines),
+		Dirty: ed.dirty,
+		Marks: map[string]int{},
+	}
+	for i, ln := range ed.file.mark {
+		if ln > 0 {
+			st.Marks[string(rune('a'+i))] = ln
+		}
+	}
+	return st
+}
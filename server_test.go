@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []string
+		want     []string
+	}{
+		{
+			name: "run",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"run","params":{"command":"a","input":["x","y","."]}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"run","params":{"command":",n"}}`,
+				`{"jsonrpc":"2.0","id":"3","method":"run","params":{"command":"5p"}}`,
+			},
+			want: []string{
+				`{"jsonrpc":"2.0","id":1,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","id":2,"result":{"output":"1\tx\n2\ty\n"}}`,
+				`{"jsonrpc":"2.0","id":"3","error":{"code":1,"message":"invalid address","data":{"output":""}}}`,
+			},
+		},
+		{
+			name: "read and state",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"run","params":{"command":"a","input":["x","y","z","."]}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"run","params":{"command":"2ka"}}`,
+				`{"jsonrpc":"2.0","id":3,"method":"read","params":{"first":2}}`,
+				`{"jsonrpc":"2.0","id":4,"method":"read","params":{"first":3,"last":4}}`,
+				`{"jsonrpc":"2.0","id":5,"method":"state"}`,
+			},
+			want: []string{
+				`{"jsonrpc":"2.0","id":1,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","id":2,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","id":3,"result":{"lines":["y","z"]}}`,
+				`{"jsonrpc":"2.0","id":4,"error":{"code":-32602,"message":"invalid address"}}`,
+				`{"jsonrpc":"2.0","id":5,"result":{"path":"","dot":3,"lines":3,"dirty":true,"marks":{"a":2}}}`,
+			},
+		},
+		{
+			name: "subscribe",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"run","params":{"command":"a","input":["a","b","c","d"]}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"subscribe"}`,
+				`{"jsonrpc":"2.0","method":"run","params":{"command":"g/[bd]/s/$/!/"}}`,
+				`{"jsonrpc":"2.0","id":3,"method":"run","params":{"command":"1d"}}`,
+				`{"jsonrpc":"2.0","id":4,"method":"unsubscribe"}`,
+				`{"jsonrpc":"2.0","id":5,"method":"run","params":{"command":"1d"}}`,
+			},
+			want: []string{
+				`{"jsonrpc":"2.0","id":1,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","id":2,"result":{"subscribed":true}}`,
+				`{"jsonrpc":"2.0","method":"changed","params":{"command":"s/$/!/","op":"delete","first":2,"last":2,"lines":["b"]}}`,
+				`{"jsonrpc":"2.0","method":"changed","params":{"command":"s/$/!/","op":"insert","first":2,"last":2,"lines":["b!"]}}`,
+				`{"jsonrpc":"2.0","method":"changed","params":{"command":"s/$/!/","op":"delete","first":4,"last":4,"lines":["d"]}}`,
+				`{"jsonrpc":"2.0","method":"changed","params":{"command":"s/$/!/","op":"insert","first":4,"last":4,"lines":["d!"]}}`,
+				`{"jsonrpc":"2.0","id":3,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","method":"changed","params":{"command":"1d","op":"delete","first":1,"last":1,"lines":["a"]}}`,
+				`{"jsonrpc":"2.0","id":4,"result":{"subscribed":false}}`,
+				`{"jsonrpc":"2.0","id":5,"result":{"output":""}}`,
+			},
+		},
+		{
+			name: "errors",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"frobnicate"}`,
+				`{"jsonrpc":"2.0","id":2,"method":"read","params":[1]}`,
+				`{"id":3,"method":"state"}`,
+				`{`,
+			},
+			want: []string{
+				`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found: frobnicate"}}`,
+				`{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"json: cannot unmarshal array into Go value of type main.rangeParams"}}`,
+				`{"jsonrpc":"2.0","id":3,"error":{"code":-32600,"message":"invalid request"}}`,
+				`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`,
+			},
+		},
+		{
+			name: "quit",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"run","params":{"command":"a","input":["x"]}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"run","params":{"command":"q"}}`,
+				`{"jsonrpc":"2.0","id":3,"method":"run","params":{"command":"q"}}`,
+				`{"jsonrpc":"2.0","id":4,"method":"state"}`,
+			},
+			want: []string{
+				`{"jsonrpc":"2.0","id":1,"result":{"output":""}}`,
+				`{"jsonrpc":"2.0","id":2,"error":{"code":1,"message":"warning: file modified","data":{"output":""}}}`,
+				`{"jsonrpc":"2.0","id":3,"result":{"output":""}}`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output strings.Builder
+			if err := serve(strings.NewReader(strings.Join(test.requests, "\n")), &output); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			if len(got) != len(test.want) {
+				t.Fatalf("want %d lines, got %d:\n%s", len(test.want), len(got), output.String())
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("line %d: want %s, got %s", i+1, test.want[i], got[i])
+				}
+			}
+		})
+	}
+}
@@ -9,16 +9,22 @@ import (
 )
 
 func (ed *Editor) handleSignals() {
-	signal.Notify(ed.sigch, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
+	signal.Notify(ed.sigch, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGWINCH)
 	for sig := range ed.sigch {
 		switch sig {
+		case syscall.SIGWINCH:
+			select {
+			case ed.winch <- struct{}{}:
+			default: // an update is already pending
+			}
+			continue
 		case syscall.SIGINT:
 			ed.err = ErrInterrupt
 			fmt.Fprintf(ed.stdout, "\n%s\n", ErrDefault)
 			// TODO(thimc): SIGINT: Return to command mode on interrupt.
 		case syscall.SIGHUP:
-			if ed.file.dirty && len(ed.file.lines) > 0 {
-				ed.file.write(DefaultHangupFile, 'w', 1, len(ed.file.lines))
+			if ed.file.dirty && len(ed.file.lines) > 0 && !ed.dryrun {
+				ed.file.write(ed.fs, DefaultHangupFile, 'w', 1, len(ed.file.lines))
 			}
 		case syscall.SIGQUIT:
 			// ignore
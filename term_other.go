@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+type termState struct{}
+
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (*termState, error) { return nil, errors.ErrUnsupported }
+
+func restoreTerm(fd int, t *termState) error { return errors.ErrUnsupported }
+
+func winsize(fd int) (rows, cols int, err error) { return 0, 0, errors.ErrUnsupported }
@@ -0,0 +1,54 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type termState = syscall.Termios
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var t syscall.Termios
+	return ioctl(fd, ioctlGetTermios, unsafe.Pointer(&t)) == nil
+}
+
+// makeRaw disables canonical mode and echoing on the terminal fd so
+// that input can be read one key at a time. Signal generation is left
+// enabled so that ^C still reaches handleSignals. The previous state
+// is returned so that it can be passed to restoreTerm.
+func makeRaw(fd int) (*termState, error) {
+	var old termState
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	t := old
+	t.Lflag &^= syscall.ICANON | syscall.ECHO
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func restoreTerm(fd int, t *termState) error {
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(t))
+}
+
+// winsize returns the number of rows and columns of the terminal fd.
+func winsize(fd int) (rows, cols int, err error) {
+	var ws struct{ row, col, xpixel, ypixel uint16 }
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.row), int(ws.col), nil
+}
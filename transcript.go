@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A transcript records a session in a txtar-like format. The comment at
+// the top holds the settings of the editor as "key: value" lines and is
+// followed by the initial buffer in a file section and by the input,
+// stdout and stderr sections in the order they occurred. A section that
+// doesn't end in a newline has the suffix " noeol" in its name, and the
+// newline that terminates it is not part of its contents.
+//
+//	ed transcript
+//	path: notes.txt
+//	-- file --
+//	hello
+//	-- input --
+//	,p
+//	-- stdout --
+//	hello
+type transcript struct {
+	settings map[string]string
+	sections []section
+}
+
+type section struct {
+	name string
+	data string
+}
+
+// A recorder writes a transcript of a session to w. Consecutive writes
+// of the same kind are collected in a single section.
+type recorder struct {
+	mu   sync.Mutex
+	w    io.Writer
+	kind string
+	buf  bytes.Buffer
+}
+
+// A recordWriter records everything written to w as a section of kind.
+type recordWriter struct {
+	rec  *recorder
+	kind string
+	w    io.Writer
+}
+
+func (rw *recordWriter) Write(p []byte) (int, error) {
+	rw.rec.add(rw.kind, p)
+	return rw.w.Write(p)
+}
+
+// WithRecord records a transcript of the session to w. The settings and
+// the buffer are recorded once all options have been applied, so the
+// file read by WithFile is the initial buffer.
+func WithRecord(w io.Writer) Option {
+	return func(ed *Editor) {
+		ed.rec = &recorder{w: w}
+	}
+}
+
+// startRecording writes the settings and the initial buffer and starts
+// recording the input and output.
+func (ed *Editor) startRecording() {
+	rec := ed.rec
+	fmt.Fprintln(rec.w, "ed transcript")
+	for _, s := range [][2]string{
+		{"path", ed.path},
+		{"prompt", ed.up},
+		{"prompting", strconv.FormatBool(ed.prompt)},
+		{"silent", strconv.FormatBool(ed.silent)},
+		{"script", strconv.FormatBool(ed.script)},
+		{"smartcase", strconv.FormatBool(ed.smartcase)},
+		{"keepundo", strconv.FormatBool(ed.keepundo)},
+	} {
+		fmt.Fprintf(rec.w, "%s: %s\n", s[0], s[1])
+	}
+	rec.add("file", []byte(ed.file.contents(1, len(ed.file.lines))))
+	ed.stdout = &recordWriter{rec: rec, kind: "stdout", w: ed.stdout}
+	ed.stderr = &recordWriter{rec: rec, kind: "stderr", w: ed.stderr}
+	ed.input.rec = func(ln string) { rec.add("input", []byte(ln+"\n")) }
+}
+
+func (r *recorder) add(kind string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if kind != r.kind {
+		r.flushLocked()
+		r.kind = kind
+	}
+	r.buf.Write(p)
+}
+
+// flush writes the section being collected.
+func (r *recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushLocked()
+}
+
+func (r *recorder) flushLocked() {
+	if r.kind == "" {
+		return
+	}
+	name, data := r.kind, r.buf.Bytes()
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		name += " noeol"
+		data = append(data, '\n')
+	}
+	fmt.Fprintf(r.w, "-- %s --\n", name)
+	r.w.Write(data)
+	r.buf.Reset()
+	r.kind = ""
+}
+
+// parseTranscript parses a transcript. Consecutive sections of the same
+// kind are joined.
+func parseTranscript(data string) (*transcript, error) {
+	t := &transcript{settings: map[string]string{}}
+	lines := strings.SplitAfter(data, "\n")
+	i := 0
+	for ; i < len(lines) && !isSectionHeader(lines[i]); i++ {
+		if k, v, ok := strings.Cut(strings.TrimSuffix(lines[i], "\n"), ": "); ok {
+			t.settings[k] = v
+		}
+	}
+	if i == 0 || strings.TrimSpace(lines[0]) != "ed transcript" {
+		return nil, errors.New("not an ed transcript")
+	}
+	for i < len(lines) && lines[i] != "" {
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(lines[i], "\n"), "-- "), " --")
+		var sb strings.Builder
+		for i++; i < len(lines) && !isSectionHeader(lines[i]); i++ {
+			sb.WriteString(lines[i])
+		}
+		s := section{name: name, data: sb.String()}
+		if n, ok := strings.CutSuffix(name, " noeol"); ok {
+			s.name, s.data = n, strings.TrimSuffix(s.data, "\n")
+		}
+		if last := len(t.sections) - 1; last >= 0 && t.sections[last].name == s.name {
+			t.sections[last].data += s.data
+			continue
+		}
+		t.sections = append(t.sections, s)
+	}
+	if len(t.sections) == 0 || t.sections[0].name != "file" {
+		return nil, errors.New("transcript without a file section")
+	}
+	return t, nil
+}
+
+func isSectionHeader(ln string) bool {
+	ln = strings.TrimSuffix(ln, "\n")
+	return strings.HasPrefix(ln, "-- ") && strings.HasSuffix(ln, " --") && len(ln) > 6
+}
+
+// replay runs the session of the transcript again with the initial
+// buffer in an in-memory file system and compares the output. Shell
+// commands are refused, since a transcript may come from anyone, and
+// the first one is reported as a difference. It returns a description
+// of the first difference, or "" if there is none.
+func replay(data string) (string, error) {
+	want, err := parseTranscript(data)
+	if err != nil {
+		return "", err
+	}
+	var (
+		in   strings.Builder
+		path = want.settings["path"]
+		mem  = NewMemFS()
+		got  strings.Builder
+	)
+	for _, s := range want.sections {
+		if s.name == "input" {
+			in.WriteString(s.data)
+		}
+	}
+	opts := []Option{
+		withEmbedded(),
+		withoutShell(),
+		WithFS(mem),
+		WithStdin(strings.NewReader(in.String())),
+		WithStdout(io.Discard),
+		WithStderr(io.Discard),
+		WithPrompt(want.settings["prompt"]),
+		WithSilent(want.settings["silent"] == "true"),
+		WithSmartCase(want.settings["smartcase"] == "true"),
+		WithKeepUndo(want.settings["keepundo"] == "true"),
+	}
+	if path != "" {
+		mem.WriteFile(path, []byte(want.sections[0].data))
+		opts = append(opts, WithFile(path))
+	}
+	ed := NewEditor(append(opts, WithRecord(&got))...)
+	ed.prompt = want.settings["prompting"] == "true"
+	ed.script = want.settings["script"] == "true"
+	ed.runEmbedded()
+	ed.rec.flush()
+
+	have, err := parseTranscript(got.String())
+	if err != nil {
+		return "", err
+	}
+	if ed.refused != "" {
+		return fmt.Sprintf("shell command not run: %q", ed.refused), nil
+	}
+	var (
+		input int    // input lines before the difference
+		last  string // last input line
+	)
+	for i := 0; i < max(len(want.sections), len(have.sections)); i++ {
+		var w, h section
+		if i < len(want.sections) {
+			w = want.sections[i]
+		}
+		if i < len(have.sections) {
+			h = have.sections[i]
+		}
+		if w != h {
+			return describeDifference(input, last, w, h), nil
+		}
+		if w.name == "input" {
+			lines := strings.Split(strings.TrimSuffix(w.data, "\n"), "\n")
+			input += len(lines)
+			last = lines[len(lines)-1]
+		}
+	}
+	return "", nil
+}
+
+// runEmbedded runs the editor like Run, but stops when it quits rather
+// than exiting.
+func (ed *Editor) runEmbedded() {
+	for {
+		err := ed.run()
+		if ed.input.pos < 0 || errors.Is(err, errQuit) {
+			return
+		}
+		if err != nil {
+			if ed.errorln(ed.verbose, err) != nil {
+				return
+			}
+			continue
+		}
+		ed.err = nil
+	}
+}
+
+func describeDifference(input int, last string, want, got section) string {
+	var sb strings.Builder
+	if input == 0 {
+		sb.WriteString("before the first input line")
+	} else {
+		fmt.Fprintf(&sb, "after input line %d (%q)", input, last)
+	}
+	for _, s := range []struct {
+		label string
+		section
+	}{{"want", want}, {"got", got}} {
+		if s.name == "" {
+			fmt.Fprintf(&sb, "\n%s: end of session", s.label)
+		} else {
+			fmt.Fprintf(&sb, "\n%s %s: %q", s.label, s.name, s.data)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	mem := NewMemFS()
+	mem.WriteFile("f", []byte("a\nb\n"))
+	var (
+		output strings.Builder
+		rec    strings.Builder
+	)
+	ed := NewEditor(WithStdout(&output), WithStderr(&output), WithFS(mem), WithFile("f"), WithPrompt(":"), WithRecord(&rec))
+	ed.script = false
+	WithStdin(strings.NewReader(",p\n3p\na\nc\n.\nw\n"))(ed)
+	ed.Run()
+
+	want := `ed transcript
+path: f
+prompt: :
+prompting: true
+silent: false
+script: false
+smartcase: false
+keepundo: false
+-- file --
+a
+b
+-- stdout noeol --
+:
+-- input --
+,p
+-- stdout noeol --
+a
+b
+:
+-- input --
+3p
+-- stderr --
+?
+-- stdout noeol --
+:
+-- input --
+a
+c
+.
+-- stdout noeol --
+:
+-- input --
+w
+-- stdout noeol --
+6
+:
+`
+	if rec.String() != want {
+		t.Fatalf("want transcript\n%s\ngot\n%s", want, rec.String())
+	}
+	if diff, err := replay(rec.String()); err != nil || diff != "" {
+		t.Fatalf("replay: %q, %v", diff, err)
+	}
+	if b, _ := mem.ReadFile("f"); string(b) != "a\nb\nc\n" {
+		t.Fatalf("want file %q, got %q", "a\nb\nc\n", b)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	const header = "ed transcript\npath: f\nsilent: true\n-- file --\na\nb\n"
+	tests := []struct {
+		name       string
+		transcript string
+		want       string
+		err        string
+	}{
+		{name: "same", transcript: header + "-- input --\n,p\n-- stdout --\na\nb\n-- input --\nq\n"},
+		{name: "differs", transcript: header + "-- input --\n1p\n-- stdout --\na\n-- input --\n2p\n-- stdout --\nx\n", want: "after input line 2 (\"2p\")\nwant stdout: \"x\\n\"\ngot stdout: \"b\\n\""},
+		{name: "missing output", transcript: header + "-- input --\nq\n-- stdout --\nx\n", want: "after input line 1 (\"q\")\nwant stdout: \"x\\n\"\ngot: end of session"},
+		{name: "modified at eof", transcript: header + "-- input --\n1d\n-- stderr --\n?\n"},
+		{name: "script error", transcript: "ed transcript\nscript: true\n-- file --\n-- input --\nH\n5p\n-- stderr --\nscript, line: 3: invalid address\n-- input --\np\n", want: "after input line 2 (\"5p\")\nwant input: \"p\\n\"\ngot: end of session"},
+		{name: "shell", transcript: header + "-- input --\n1r !echo x\n-- stdout --\n2\n", want: "shell command not run: \"echo x\""},
+		{name: "not a transcript", transcript: "hello\n", err: "not an ed transcript"},
+		{name: "no file", transcript: "ed transcript\n-- input --\n,p\n", err: "transcript without a file section"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff, err := replay(test.transcript)
+			if err != nil {
+				if err.Error() != test.err {
+					t.Fatalf("want error %q, got %q", test.err, err)
+				}
+				return
+			} else if test.err != "" {
+				t.Fatalf("want error %q", test.err)
+			}
+			if diff != test.want {
+				t.Fatalf("want %q, got %q", test.want, diff)
+			}
+		})
+	}
+}
+
+func TestReplayShell(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	for _, input := range []string{"!touch " + marker, "r !touch " + marker, "e !touch " + marker} {
+		transcript := "ed transcript\npath: f\n-- file --\na\n-- input --\n" + input + "\n-- stdout --\n!\n"
+		diff, err := replay(transcript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "shell command not run: \"touch " + marker + "\""; diff != want {
+			t.Fatalf("%s: want %q, got %q", input, want, diff)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("%s: shell command was run", input)
+		}
+	}
+}
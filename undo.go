@@ -1,5 +1,11 @@
 package main
 
+import (
+	"slices"
+	"strconv"
+	"time"
+)
+
 // undoType determines how the history entry should behandled,
 // undoTypeDelete removes lines and undoTypeAdd adds lines.
 type undoType int
@@ -15,43 +21,100 @@ type undoAction struct {
 	lines []string
 }
 
+// An undoGroup holds the actions that undo a command. size is the
+// number of bytes of the lines it keeps and time is when the command
+// was run. If the command changed the file name, renamed is set and
+// path is the name to restore.
+type undoGroup struct {
+	actions []undoAction
+	size    int
+	time    time.Time
+	renamed bool
+	path    string
+}
+
 type undo struct {
 	action  []undoAction // history for a command in progress
-	history [][]undoAction
+	history []undoGroup
 	global  []undoAction
+	undone  []undoGroup // groups that redo what was undone, most recent last
+	renamed bool        // the command in progress changed the file name
+	oldpath string      // file name before the command in progress
+
+	limit int              // maximum number of groups in the history, or 0
+	max   int              // maximum number of bytes in the history, or 0
+	size  int              // bytes in the history
+	now   func() time.Time // clock for the time of a group, or nil for time.Now
 }
 
 func (u *undo) clear() { u.action = []undoAction{} }
 
 func (u *undo) reset() {
 	u.clear()
-	u.history = [][]undoAction{}
+	u.global = nil
+	u.history = []undoGroup{}
+	u.undone = nil
+	u.size = 0
+	u.renamed, u.oldpath = false, ""
 }
+
+// pop undoes the most recent group of the history, such that it can be
+// redone.
 func (u *undo) pop(ed *Editor) error {
 	if len(u.history) < 1 {
 		return ErrNothingToUndo
 	}
-	action := u.history[len(u.history)-1]
-	for i := len(action) - 1; i >= 0; i-- {
-		a := action[i]
+	group := u.history[len(u.history)-1]
+	u.history = u.history[:len(u.history)-1]
+	u.size -= group.size
+	u.undone = append(u.undone, u.apply(ed, group))
+	return nil
+}
+
+// redo redoes the most recently undone group.
+func (u *undo) redo(ed *Editor) error {
+	if len(u.undone) < 1 {
+		return ErrNothingToRedo
+	}
+	group := u.undone[len(u.undone)-1]
+	u.undone = u.undone[:len(u.undone)-1]
+	group = u.apply(ed, group)
+	u.history = append(u.history, group)
+	u.size += group.size
+	u.trim()
+	return nil
+}
+
+// apply applies the actions of group in reverse and returns the group
+// that reverts them, with the same time.
+func (u *undo) apply(ed *Editor, group undoGroup) undoGroup {
+	inverse := undoGroup{time: group.time}
+	if group.renamed {
+		inverse.renamed, inverse.path = true, ed.file.path
+		ed.file.path = group.path
+	}
+	dot := ed.dot
+	for i := len(group.actions) - 1; i >= 0; i-- {
+		a := group.actions[i]
 		before := ed.file.lines[:a.first-1]
 		after := ed.file.lines[a.first-1:]
 		switch a.typ {
 		case undoTypeDelete:
+			lines := slices.Clone(ed.file.lines[a.first-1 : a.second])
+			ed.deleted(a.first, lines)
 			after = ed.file.lines[a.second:]
 			ed.file.lines = append(before, after...)
+			inverse.actions = append(inverse.actions, undoAction{typ: undoTypeAdd, cursor: cursor{first: a.first, second: a.second, dot: dot}, lines: lines})
+			inverse.size += linesSize(lines)
 		case undoTypeAdd:
 			ed.file.lines = append(before, append(a.lines, after...)...)
+			ed.inserted(a.first, a.lines)
+			inverse.actions = append(inverse.actions, undoAction{typ: undoTypeDelete, cursor: cursor{first: a.first, second: a.first + len(a.lines) - 1, dot: dot}})
 		}
 		ed.dot = a.dot
 		ed.file.dirty = true
 	}
-	if count := len(u.history) - 1; count > 0 {
-		u.history = u.history[:count]
-	} else {
-		u.history = [][]undoAction{}
-	}
-	return nil
+	return inverse
 }
 
 func (u *undo) append(typ undoType, cur cursor, lines []string) {
@@ -62,16 +125,246 @@ func (u *undo) append(typ undoType, cur cursor, lines []string) {
 	})
 }
 
+// rename records that the command in progress changed the file name
+// from path.
+func (u *undo) rename(path string) {
+	if !u.renamed {
+		u.renamed, u.oldpath = true, path
+	}
+}
+
 func (u *undo) store(g bool) {
+	if len(u.action) == 0 && !u.renamed {
+		return
+	}
 	if g {
 		u.global = append(u.global, u.action...)
 	} else {
-		u.history = append(u.history, u.action)
+		u.push(u.action)
 	}
 	u.clear()
 }
 
 func (u *undo) storeGlobal() {
-	u.history = append(u.history, u.global)
+	u.push(u.global)
+	u.global = nil
 	u.clear()
 }
+
+// push adds the actions of a command to the history as a group, merged
+// by compact, together with the file name the command replaced, and
+// drops the oldest groups while the history exceeds its limits. The
+// most recent group is always kept. What was undone can no longer be
+// redone.
+func (u *undo) push(actions []undoAction) {
+	group := undoGroup{time: u.clock(), renamed: u.renamed, path: u.oldpath}
+	u.renamed, u.oldpath = false, ""
+	if len(actions) > 0 {
+		group.actions = compact(actions)
+	}
+	if len(group.actions) == 0 && !group.renamed {
+		return // the changes cancel out
+	}
+	for _, a := range group.actions {
+		group.size += linesSize(a.lines)
+	}
+	u.history = append(u.history, group)
+	u.undone = nil
+	u.size += group.size
+	u.trim()
+}
+
+func (u *undo) clock() time.Time {
+	if u.now != nil {
+		return u.now()
+	}
+	return time.Now()
+}
+
+// linesSize returns the number of bytes of lines, counting newlines.
+func linesSize(lines []string) int {
+	size := len(lines)
+	for _, ln := range lines {
+		size += len(ln)
+	}
+	return size
+}
+
+// trim drops the oldest groups of the history until it's within its
+// limits, but never the most recent one.
+func (u *undo) trim() {
+	n := 0
+	for size := u.size; n < len(u.history)-1; n++ {
+		if (u.limit <= 0 || len(u.history)-n <= u.limit) && (u.max <= 0 || size <= u.max) {
+			break
+		}
+		size -= u.history[n].size
+	}
+	if n == 0 {
+		return
+	}
+	for _, g := range u.history[:n] {
+		u.size -= g.size
+	}
+	u.history = append([]undoGroup{}, u.history[n:]...)
+}
+
+// An undoHunk describes how to undo a change to consecutive lines: the
+// n lines at first in the changed buffer replaced the lines old.
+type undoHunk struct {
+	first int
+	n     int
+	old   []string
+}
+
+// compact merges the actions of a command into the hunks it changed,
+// such that lines changed several times only keep their original
+// contents and lines changed together are restored together. It
+// returns an action adding the old lines and one deleting the new ones
+// for every hunk, or nothing if the changes cancel out.
+func compact(actions []undoAction) []undoAction {
+	var hunks []undoHunk
+	for _, a := range actions {
+		switch a.typ {
+		case undoTypeDelete:
+			hunks = insertHunk(hunks, a.first, a.second-a.first+1)
+		case undoTypeAdd:
+			hunks = removeHunk(hunks, a.first, a.lines)
+		}
+	}
+	dot := actions[0].dot
+	var merged []undoAction
+	for _, h := range hunks {
+		if len(h.old) > 0 {
+			merged = append(merged, undoAction{typ: undoTypeAdd, cursor: cursor{first: h.first, second: h.first + len(h.old) - 1, dot: dot}, lines: h.old})
+		}
+		if h.n > 0 {
+			merged = append(merged, undoAction{typ: undoTypeDelete, cursor: cursor{first: h.first, second: h.first + h.n - 1, dot: dot}})
+		}
+	}
+	return merged
+}
+
+// insertHunk records that n lines were inserted at the line first.
+// Hunks don't overlap or touch and are sorted by their first line.
+func insertHunk(hunks []undoHunk, first, n int) []undoHunk {
+	if n <= 0 {
+		return hunks
+	}
+	i := 0
+	for i < len(hunks) && hunks[i].first+hunks[i].n < first {
+		i++
+	}
+	if i < len(hunks) && hunks[i].first <= first {
+		hunks[i].n += n
+	} else {
+		hunks = append(hunks[:i], append([]undoHunk{{first: first, n: n}}, hunks[i:]...)...)
+	}
+	for j := i + 1; j < len(hunks); j++ {
+		hunks[j].first += n
+	}
+	return hunks
+}
+
+// removeHunk records that the lines at first were deleted. The lines
+// that weren't part of a hunk yet are original lines and become part of
+// the old lines of the merged hunk.
+func removeHunk(hunks []undoHunk, first int, lines []string) []undoHunk {
+	m := len(lines)
+	if m == 0 {
+		return hunks
+	}
+	i := 0
+	for i < len(hunks) && hunks[i].first+hunks[i].n < first {
+		i++
+	}
+	j := i
+	for j < len(hunks) && hunks[j].first <= first+m {
+		j++
+	}
+	start, end := first, first+m
+	if i < j {
+		start, end = min(start, hunks[i].first), max(end, hunks[j-1].first+hunks[j-1].n)
+	}
+	var (
+		old   []string
+		k     = i
+		inNew = 0 // end of the new lines of the current hunk
+	)
+	for x := start; x <= end; x++ {
+		for ; k < j && hunks[k].first == x; k++ {
+			old = append(old, hunks[k].old...)
+			inNew = x + hunks[k].n
+		}
+		if x < end && x >= inNew {
+			old = append(old, lines[x-first])
+		}
+	}
+	h := undoHunk{first: start, n: end - start - m, old: old}
+	hunks = append(hunks[:i], append([]undoHunk{h}, hunks[j:]...)...)
+	for k := i + 1; k < len(hunks); k++ {
+		hunks[k].first -= m
+	}
+	return hunks
+}
+
+// earlier undoes the changes made in the span d before the time of the
+// current state, or n groups if d is 0. It undoes at least one group.
+func (u *undo) earlier(ed *Editor, n int, d time.Duration) error {
+	if len(u.history) < 1 {
+		return ErrNothingToUndo
+	}
+	since := u.history[len(u.history)-1].time.Add(-d)
+	for i := 0; i < n || d > 0; i++ {
+		if len(u.history) < 1 || d > 0 && !u.history[len(u.history)-1].time.After(since) {
+			break
+		}
+		u.pop(ed)
+	}
+	return nil
+}
+
+// later redoes the changes made in the span d after the time of the
+// current state, or n groups if d is 0. It redoes at least one group.
+func (u *undo) later(ed *Editor, n int, d time.Duration) error {
+	if len(u.undone) < 1 {
+		return ErrNothingToRedo
+	}
+	cur := u.undone[len(u.undone)-1].time
+	if len(u.history) > 0 {
+		cur = u.history[len(u.history)-1].time
+	}
+	until := cur.Add(d)
+	for i := 0; i < n || d > 0; i++ {
+		if len(u.undone) < 1 || i > 0 && d > 0 && u.undone[len(u.undone)-1].time.After(until) {
+			break
+		}
+		u.redo(ed)
+	}
+	return nil
+}
+
+// parseTimeOffset parses the argument of earlier and later: a count of
+// changes or a number followed by one of the units s, m, h and d. An
+// empty argument is a count of 1.
+func parseTimeOffset(s string) (int, time.Duration, error) {
+	if s == "" {
+		return 1, 0, nil
+	}
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+	unit, ok := units[s[len(s)-1]]
+	if ok {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || s[0] == '+' {
+		return 0, 0, ErrInvalidTimeOffset
+	}
+	if !ok {
+		return n, 0, nil
+	}
+	if time.Duration(n) > time.Duration(1<<63-1)/unit {
+		return 0, 0, ErrNumberOutOfRange
+	}
+	return 0, time.Duration(n) * unit, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUndoCompact(t *testing.T) {
+	tests := []struct {
+		script  string
+		actions int // actions in the last history group
+		buf     []string
+	}{
+		{script: "g/./s/$/x/", actions: 2, buf: []string{"ax", "bx", "cx", "dx"}},
+		{script: "g/[ac]/s/$/x/", actions: 4, buf: []string{"ax", "b", "cx", "d"}},
+		{script: "g/a/s/a/x/\\\ns/x/y/\\\ns/y/z/", actions: 2, buf: []string{"z", "b", "c", "d"}},
+		{script: "g/./m0", actions: 2, buf: []string{"d", "c", "b", "a"}},
+		{script: "g/b/a\\\nx\\\n.\\\n.d", actions: 0, buf: []string{"a", "b", "c", "d"}},
+		{script: "2,3j", actions: 2, buf: []string{"a", "bc", "d"}},
+		{script: "1,2t4", actions: 1, buf: []string{"a", "b", "c", "d", "a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.script, func(t *testing.T) {
+			orig := []string{"a", "b", "c", "d"}
+			ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), withBuffer(file{lines: slices.Clone(orig)}))
+			WithStdin(strings.NewReader(test.script))(ed)
+			if err := ed.run(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ed.file.lines, test.buf) {
+				t.Fatalf("want buffer %q, got %q", test.buf, ed.file.lines)
+			}
+			var actions int
+			if n := len(ed.undo.history); n > 0 {
+				actions = len(ed.undo.history[n-1].actions)
+			}
+			if actions != test.actions {
+				t.Fatalf("want %d actions, got %d", test.actions, actions)
+			}
+			if actions == 0 {
+				return
+			}
+			if err := ed.undo.pop(ed); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ed.file.lines, orig) {
+				t.Fatalf("want buffer %q after undo, got %q", orig, ed.file.lines)
+			}
+		})
+	}
+}
+
+func TestUndoLimit(t *testing.T) {
+	tests := []struct {
+		entries, bytes int
+		undos          int
+		size           int
+	}{
+		{undos: 4, size: 8},
+		{entries: 2, undos: 2, size: 4},
+		{bytes: 5, undos: 2, size: 4},
+		{bytes: 1, undos: 1, size: 2},
+	}
+	for _, test := range tests {
+		ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), withBuffer(file{lines: []string{"a", "b", "c", "d"}}), WithUndoLimit(test.entries, test.bytes))
+		WithStdin(strings.NewReader("1d\n1d\n1d\n1d\n"))(ed)
+		for range 4 {
+			if err := ed.run(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ed.undo.size != test.size {
+			t.Fatalf("%d, %d: want size %d, got %d", test.entries, test.bytes, test.size, ed.undo.size)
+		}
+		var undos int
+		for ed.undo.pop(ed) == nil {
+			undos++
+		}
+		if undos != test.undos {
+			t.Fatalf("%d, %d: want %d undos, got %d", test.entries, test.bytes, test.undos, undos)
+		}
+		if ed.undo.size != 0 {
+			t.Fatalf("%d, %d: want size 0 after undoing everything, got %d", test.entries, test.bytes, ed.undo.size)
+		}
+	}
+}
+
+func TestEarlierLater(t *testing.T) {
+	// The lines are appended at the minutes 0, 1, 2 and 10.
+	minutes := []int{0, 1, 2, 10}
+	tests := []struct {
+		script string
+		buf    string
+		err    error
+	}{
+		{script: "earlier", buf: "abc"},
+		{script: "earlier 3", buf: "a"},
+		{script: "earlier 9", buf: ""},
+		{script: "earlier 1m", buf: "abc"},
+		{script: "earlier 9m", buf: "ab"},
+		{script: "earlier 10m", buf: "a"},
+		{script: "earlier 11m", buf: ""},
+		{script: "earlier 1h", buf: ""},
+		{script: "earlier 9m\nearlier 1m", buf: "a"},
+		{script: "earlier 10m\nlater 1m", buf: "ab"},
+		{script: "earlier 10m\nlater 2", buf: "abc"},
+		{script: "earlier 10m\nlater 3m", buf: "abc"},
+		{script: "earlier 10m\nlater 1d", buf: "abcd"},
+		{script: "u\nu\nlater", buf: "abc"},
+		{script: "earlier 2\na\nx\n.\nlater", buf: "abx", err: ErrNothingToRedo},
+		{script: "later", buf: "abcd", err: ErrNothingToRedo},
+		{script: "earlier 5x", buf: "abcd", err: ErrInvalidTimeOffset},
+		{script: "earlier 0", buf: "abcd", err: ErrInvalidTimeOffset},
+		{script: "1earlier", buf: "abcd", err: ErrUnexpectedAddress},
+	}
+	for _, test := range tests {
+		t.Run(test.script, func(t *testing.T) {
+			var now time.Time
+			ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard))
+			ed.undo.now = func() time.Time { return now }
+			for i, m := range minutes {
+				now = time.Date(2024, 1, 1, 12, m, 0, 0, time.UTC)
+				ed.append(i, []string{string(rune('a' + i))})
+			}
+			now = now.Add(time.Hour)
+			WithStdin(strings.NewReader(test.script))(ed)
+			var err error
+			for err == nil && ed.input.pos >= 0 {
+				err = ed.run()
+			}
+			if err == ErrFileModified {
+				err = nil // end of input
+			}
+			if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if buf := strings.Join(ed.file.lines, ""); buf != test.buf {
+				t.Fatalf("want buffer %q, got %q", test.buf, buf)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An undo file holds the undo history of a file, together with the hash
+// of the contents that it applies to.
+type undoFile struct {
+	Version int             `json:"version"`
+	Hash    string          `json:"hash"`
+	History []undoFileGroup `json:"history"`
+	Undone  []undoFileGroup `json:"undone,omitempty"`
+}
+
+type undoFileGroup struct {
+	Time    time.Time        `json:"time"`
+	Path    *string          `json:"path,omitempty"` // file name to restore
+	Actions []undoFileAction `json:"actions"`
+}
+
+type undoFileAction struct {
+	Op     string   `json:"op"` // "add" or "delete"
+	First  int      `json:"first"`
+	Second int      `json:"second"`
+	Dot    int      `json:"dot"`
+	Lines  []string `json:"lines,omitempty"`
+}
+
+const undoFileVersion = 1
+
+// WithUndoFile keeps the undo history of a file in a hidden file next
+// to it, so that u keeps working after the editor is restarted. The
+// history is written when the whole buffer is written to the file and
+// read back when the file is edited, unless the file has changed in the
+// meantime. It must precede WithFile.
+func WithUndoFile(t bool) Option {
+	return func(ed *Editor) {
+		ed.undofile = t
+	}
+}
+
+// undoFilePath returns the path of the undo file of path.
+func undoFilePath(path string) string {
+	dir, base := filepath.Split(path)
+	return filepath.Join(dir, "."+base+".undo")
+}
+
+// hashLines returns the hash of the lines as they're written to a file.
+func hashLines(lines []string) string {
+	h := sha256.New()
+	for _, ln := range lines {
+		h.Write([]byte(ln))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// saveUndo writes the undo history to the undo file of path, which has
+// just been written with the contents of the buffer.
+func (ed *Editor) saveUndo(path string) error {
+	uf := undoFile{
+		Version: undoFileVersion,
+		Hash:    hashLines(ed.file.lines),
+		History: encodeGroups(ed.undo.history),
+		Undone:  encodeGroups(ed.undo.undone),
+	}
+	data, err := json.Marshal(uf)
+	if err != nil {
+		return ErrCannotWriteUndo
+	}
+	f, err := ed.fs.OpenFile(undoFilePath(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return ErrCannotWriteUndo
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return ErrCannotWriteUndo
+	}
+	if err := f.Close(); err != nil {
+		return ErrCannotWriteUndo
+	}
+	return nil
+}
+
+// loadUndo replaces the undo history with the one in the undo file of
+// the current file if it applies to the buffer. Missing, stale and
+// malformed undo files are ignored.
+func (ed *Editor) loadUndo() {
+	data, err := ed.fs.ReadFile(undoFilePath(ed.file.path))
+	if err != nil {
+		return
+	}
+	var uf undoFile
+	if err := json.Unmarshal(data, &uf); err != nil || uf.Version != undoFileVersion || uf.Hash != hashLines(ed.file.lines) {
+		return
+	}
+	history, ok := decodeGroups(uf.History)
+	if !ok || !validGroups(len(ed.file.lines), history) {
+		return
+	}
+	undone, ok := decodeGroups(uf.Undone)
+	if !ok || !validGroups(len(ed.file.lines), undone) {
+		return
+	}
+	ed.undo.reset()
+	ed.undo.history, ed.undo.undone = history, undone
+	for _, g := range history {
+		ed.undo.size += g.size
+	}
+	ed.undo.trim()
+}
+
+func encodeGroups(groups []undoGroup) []undoFileGroup {
+	var enc []undoFileGroup
+	for _, g := range groups {
+		eg := undoFileGroup{Time: g.time}
+		if g.renamed {
+			eg.Path = &g.path
+		}
+		for _, a := range g.actions {
+			op := "add"
+			if a.typ == undoTypeDelete {
+				op = "delete"
+			}
+			eg.Actions = append(eg.Actions, undoFileAction{Op: op, First: a.first, Second: a.second, Dot: a.dot, Lines: a.lines})
+		}
+		enc = append(enc, eg)
+	}
+	return enc
+}
+
+func decodeGroups(enc []undoFileGroup) ([]undoGroup, bool) {
+	var groups []undoGroup
+	for _, eg := range enc {
+		g := undoGroup{time: eg.Time}
+		if eg.Path != nil {
+			g.renamed, g.path = true, *eg.Path
+		}
+		for _, ea := range eg.Actions {
+			a := undoAction{cursor: cursor{first: ea.First, second: ea.Second, dot: ea.Dot}, lines: ea.Lines}
+			switch ea.Op {
+			case "add":
+				a.typ = undoTypeAdd
+				g.size += linesSize(a.lines)
+			case "delete":
+				a.typ = undoTypeDelete
+			default:
+				return nil, false
+			}
+			g.actions = append(g.actions, a)
+		}
+		if len(g.actions) == 0 && !g.renamed {
+			return nil, false
+		}
+		groups = append(groups, g)
+	}
+	return groups, true
+}
+
+// validGroups reports whether the groups can be applied one after the
+// other to a buffer of n lines, the last one first, like the history
+// is undone and the undone groups are redone.
+func validGroups(n int, groups []undoGroup) bool {
+	for i := len(groups) - 1; i >= 0; i-- {
+		g := groups[i]
+		for j := len(g.actions) - 1; j >= 0; j-- {
+			a := g.actions[j]
+			switch a.typ {
+			case undoTypeDelete:
+				if a.first < 1 || a.second < a.first-1 || a.second > n {
+					return false
+				}
+				n -= a.second - a.first + 1
+			case undoTypeAdd:
+				if a.first < 1 || a.first > n+1 {
+					return false
+				}
+				n += len(a.lines)
+			}
+		}
+		if len(g.actions) > 0 && (g.actions[0].dot < 0 || g.actions[0].dot > n) {
+			return false
+		}
+	}
+	return true
+}
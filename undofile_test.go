@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUndoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string // run before the file is edited again
+		change  string // written to the file in between, if not empty
+		script  string
+		buf     []string
+		err     error
+	}{
+		{name: "restored", session: "1d\n$a\nd\n.\nw\n", script: "u\nu\n", buf: []string{"a", "b", "c"}},
+		{name: "redo", session: "1d\nu\nw\n", script: "later\n", buf: []string{"b", "c"}},
+		{name: "stale", session: "1d\nw\n", change: "x\n", script: "u\n", buf: []string{"x"}, err: ErrNothingToUndo},
+		{name: "partial write", session: "1d\n1w\n", script: "u\n", buf: []string{"b"}, err: ErrNothingToUndo},
+		{name: "other file", session: "1d\nw g\ne g\n", script: "u\n", buf: []string{"a", "b", "c"}, err: ErrNothingToUndo},
+		{name: "edited", session: "2d\nw\ne g\n", script: "e f\nu\n", buf: []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mem := NewMemFS()
+			mem.WriteFile("f", []byte("a\nb\nc\n"))
+			mem.WriteFile("g", []byte("a\nb\nc\n"))
+			run := func(script string) (*Editor, error) {
+				ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(mem), WithUndoFile(true), WithFile("f"))
+				WithStdin(strings.NewReader(script))(ed)
+				var err error
+				for err == nil && ed.input.pos >= 0 {
+					err = ed.run()
+				}
+				if err == ErrFileModified {
+					err = nil // end of input
+				}
+				return ed, err
+			}
+			if _, err := run(test.session); err != nil {
+				t.Fatal(err)
+			}
+			if test.change != "" {
+				mem.WriteFile("f", []byte(test.change))
+			}
+			ed, err := run(test.script)
+			if err != test.err {
+				t.Fatalf("want error %v, got %v", test.err, err)
+			}
+			if !slices.Equal(ed.file.lines, test.buf) {
+				t.Fatalf("want buffer %q, got %q", test.buf, ed.file.lines)
+			}
+		})
+	}
+}
+
+func TestUndoFileMalformed(t *testing.T) {
+	for _, data := range []string{
+		"not json",
+		`{"version":2,"hash":"` + hashLines([]string{"a"}) + `","history":[]}`,
+		`{"version":1,"hash":"` + hashLines([]string{"a"}) + `","history":[{"actions":[{"op":"delete","first":1,"second":5}]}]}`,
+		`{"version":1,"hash":"` + hashLines([]string{"a"}) + `","history":[{"actions":[{"op":"move","first":1,"second":1}]}]}`,
+	} {
+		mem := NewMemFS()
+		mem.WriteFile("f", []byte("a\n"))
+		mem.WriteFile(".f.undo", []byte(data))
+		ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(mem), WithUndoFile(true), WithFile("f"))
+		if len(ed.undo.history) != 0 {
+			t.Fatalf("%s: loaded %d groups", data, len(ed.undo.history))
+		}
+	}
+}
+
+func TestUndoFileRenamed(t *testing.T) {
+	mem := NewMemFS()
+	mem.WriteFile("f", []byte("a\n"))
+	mem.WriteFile("g", []byte("x\ny\n"))
+	ed := NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(mem), WithUndoFile(true), WithKeepUndo(true), WithFile("g"))
+	WithStdin(strings.NewReader("e f\nw\n"))(ed)
+	for ed.input.pos >= 0 {
+		if err := ed.run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ed = NewEditor(WithStdout(io.Discard), WithStderr(io.Discard), WithFS(mem), WithUndoFile(true), WithFile("f"))
+	WithStdin(strings.NewReader("u\n"))(ed)
+	if err := ed.run(); err != nil {
+		t.Fatal(err)
+	}
+	if ed.file.path != "g" || !slices.Equal(ed.file.lines, []string{"x", "y"}) {
+		t.Fatalf("want g %q, got %s %q", []string{"x", "y"}, ed.file.path, ed.file.lines)
+	}
+}